@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kotodama-kamataichi/internal/netroute"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+// doctorTargets are the hosts checked when none are given. The platform
+// ones are the API hosts their method configs usually call.
+var doctorTargets = []struct{ platform, url string }{
+	{"", tunehub.DefaultBaseURL},
+	{"netease", "https://music.163.com/api/"},
+	{"netease", "https://interface3.music.163.com/"},
+	{"qq", "https://c.y.qq.com/"},
+	{"qq", "https://u.y.qq.com/"},
+	{"kuwo", "https://www.kuwo.cn/"},
+}
+
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	opts := newOptions(flags)
+	platform := flags.String("platform", "", "treat the given hosts as API calls for this platform")
+	probe := flags.Bool("probe", false, "also send a GET to each host and report the outcome")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer opts.close()
+	rt, err := opts.newRouter(tunehub.DefaultTimeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	targets := doctorTargets
+	if flags.NArg() > 0 {
+		targets = nil
+		for _, a := range flags.Args() {
+			if !strings.Contains(a, "://") {
+				a = "https://" + a + "/"
+			}
+			targets = append(targets, struct{ platform, url string }{*platform, a})
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "FOR\tURL\tRULE\tSENDS TO\tIP\tHTTP\tPROXY"
+	if *probe {
+		header += "\tPROBE"
+	}
+	fmt.Fprintln(tw, header)
+	failed := 0
+	for _, t := range targets {
+		u, err := url.Parse(t.url)
+		if err != nil || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "bad url %q\n", t.url)
+			failed++
+			continue
+		}
+		d := rt.Decide(u, t.platform)
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s",
+			orDash(firstOf(t.platform, "tunehub")), u.String(), orDash(d.Rule), d.Host,
+			orDash(d.IPFamily), httpVersion(d.HTTP1), describeProxy(d, u))
+		if *probe {
+			res, ok := probeURL(rt, u, t.platform)
+			if !ok {
+				failed++
+			}
+			line += "\t" + res
+		}
+		fmt.Fprintln(tw, line)
+	}
+	if err := tw.Flush(); err != nil {
+		return 1
+	}
+	fmt.Println("downloads use the same rules but not the platform proxies")
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func describeProxy(d netroute.Decision, u *url.URL) string {
+	switch {
+	case d.Proxy == "direct":
+		return "direct (" + d.ProxyFrom + ")"
+	case d.Proxy != "":
+		p := d.Proxy
+		if pu, err := url.Parse(p); err == nil {
+			p = pu.Redacted()
+		}
+		return p + " (" + d.ProxyFrom + ")"
+	}
+	env, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: u.Scheme, Host: d.Host}})
+	if err != nil || env == nil {
+		return "direct (environment)"
+	}
+	return env.Redacted() + " (environment)"
+}
+
+func probeURL(rt *netroute.Router, u *url.URL, platform string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if platform != "" {
+		ctx = netroute.WithPlatform(ctx, platform)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err.Error(), false
+	}
+	req.Header.Set("User-Agent", "kotodama-kamataichi")
+	start := time.Now()
+	res, err := rt.Route(req).Do(req)
+	if err != nil {
+		return "FAIL " + err.Error(), false
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	res.Body.Close()
+	// Any answer means the route works; API roots often 404.
+	return fmt.Sprintf("http %d in %s", res.StatusCode, time.Since(start).Round(time.Millisecond)), true
+}
+
+func httpVersion(http1 bool) string {
+	if http1 {
+		return "1.1"
+	}
+	return "auto"
+}
+
+func firstOf(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
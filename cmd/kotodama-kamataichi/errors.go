@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
+)
+
+// Exit codes beyond 0 (ok), 1 (something failed) and 2 (bad usage).
+const (
+	exitAuth        = 3
+	exitQuota       = 4
+	exitUnavailable = 5
+)
+
+// describe renders err with the hint of the first typed error in its chain.
+func describe(err error) string {
+	var h interface{ Hint() string }
+	if errors.As(err, &h) && h.Hint() != "" {
+		return err.Error() + "\n  hint: " + h.Hint()
+	}
+	return err.Error()
+}
+
+func exitCode(err error) int {
+	var te *tunehub.Error
+	if errors.As(err, &te) {
+		switch te.Reason {
+		case tunehub.ReasonAuth:
+			return exitAuth
+		case tunehub.ReasonQuota:
+			return exitQuota
+		case tunehub.ReasonNetwork, tunehub.ReasonService, tunehub.ReasonUpstream:
+			return exitUnavailable
+		}
+		return 1
+	}
+	var be *usage.BudgetError
+	if errors.As(err, &be) {
+		return exitQuota
+	}
+	var he *download.HTTPError
+	if errors.As(err, &he) && he.Retryable() {
+		return exitUnavailable
+	}
+	return 1
+}
+
+// failures counts failed items and keeps the most telling exit code: a bad
+// key or an empty quota explains every other failure, so they win.
+type failures struct {
+	n    int
+	code int
+}
+
+func (f *failures) add(err error) {
+	f.addN(1, err)
+}
+
+func (f *failures) addN(n int, err error) {
+	f.n += n
+	code := exitCode(err)
+	if f.code == 0 || exitRank(code) < exitRank(f.code) {
+		f.code = code
+	}
+}
+
+func (f *failures) exit() int {
+	if f.n == 0 {
+		return 0
+	}
+	return f.code
+}
+
+func exitRank(code int) int {
+	switch code {
+	case exitAuth:
+		return 0
+	case exitQuota:
+		return 1
+	case exitUnavailable:
+		return 2
+	}
+	return 3
+}
+
+func printErr(err error) {
+	fmt.Fprintln(os.Stderr, describe(err))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/playlist"
+	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
+)
+
+func runGet(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	opts := newOptions(flags)
+	platform := flags.String("platform", "netease", "platform of bare IDs (links carry their own)")
+	quality := flags.String("quality", "320k", "requested quality")
+	apiKey := flags.String("key", os.Getenv("TUNEHUB_API_KEY"), "TuneHub API key (default $TUNEHUB_API_KEY)")
+	playlistName := flags.String("playlist-name", "", "playlist file name without extension (default batch-<time>)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer opts.close()
+	args = flags.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: kotodama-kamataichi get [flags] ID|URL...")
+		return 2
+	}
+
+	th, err := opts.newClient()
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+	warnOverrides(th)
+	dl, closeDL, err := opts.newDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer closeDL()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := download.CleanStaging(opts.outDir, staleStagingAge); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: cleaning staging dir:", err)
+	}
+
+	batchName := *playlistName
+	if batchName == "" {
+		batchName = "get-" + time.Now().Format("20060102-150405")
+	}
+	ctx = usage.WithBatch(ctx, batchName)
+	var fails failures
+	groups, collName := resolveTargets(ctx, th, *platform, args, &fails)
+	var items []tunehub.ParseItem
+	for _, g := range groups {
+		items = append(items, parseItems(ctx, th, *apiKey, g.platform, *quality, g.ids, &fails)...)
+	}
+	results, warnings, err := dl.DownloadBatch(ctx, opts.outDir, items, func(ev download.BatchEvent) {
+		if !ev.Done {
+			return
+		}
+		printBatchResult(ev.Result)
+	})
+	dlFailed := 0
+	for _, br := range results {
+		if br.Err != nil {
+			dlFailed++
+			fails.add(br.Err)
+		}
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	if opts.playlistFmt != "" && len(results)-dlFailed > 0 {
+		name := *playlistName
+		if name == "" && collName != "" {
+			name = download.SanitizeName(collName)
+		}
+		if name == "" {
+			name = "batch-" + time.Now().Format("20060102-150405")
+		}
+		p := filepath.Join(opts.outDir, name+playlist.Ext(opts.playlistFmt))
+		if perr := playlist.WriteFile(p, opts.playlistFmt, playlist.FromBatch(results)); perr != nil {
+			fmt.Fprintln(os.Stderr, "warning: writing playlist:", perr)
+		} else {
+			fmt.Println("playlist:", p)
+		}
+	}
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+	fmt.Printf("%d downloaded, %d failed\n", len(results)-dlFailed, fails.n)
+	return fails.exit()
+}
+
+type idGroup struct {
+	platform string
+	ids      []string
+}
+
+// resolveTargets expands command-line arguments into song IDs grouped by
+// platform, in first-seen order. Arguments are bare IDs on defPlatform or
+// share links; album, playlist and artist links expand to their tracks.
+// collName is set when exactly one collection was given.
+func resolveTargets(ctx context.Context, th *tunehub.Client, defPlatform string, args []string, fails *failures) (groups []idGroup, collName string) {
+	add := func(platform string, ids ...string) {
+		for i := range groups {
+			if groups[i].platform == platform {
+				groups[i].ids = append(groups[i].ids, ids...)
+				return
+			}
+		}
+		groups = append(groups, idGroup{platform: platform, ids: ids})
+	}
+	colls := 0
+	for _, arg := range args {
+		if !tunehub.LooksLikeLink(arg) {
+			add(defPlatform, arg)
+			continue
+		}
+		link, err := th.ResolveLink(ctx, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", arg, describe(err))
+			fails.add(err)
+			continue
+		}
+		if link.Kind == tunehub.KindSong {
+			add(link.Platform, link.ID)
+			continue
+		}
+		coll, err := th.OpenCollection(ctx, link.Platform, link.Kind, link.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAIL %s %s: %s\n", link.Kind, link.ID, describe(err))
+			fails.add(err)
+			continue
+		}
+		colls++
+		collName = coll.Name
+		ids := make([]string, 0, len(coll.Tracks))
+		for _, t := range coll.Tracks {
+			ids = append(ids, t.ID)
+		}
+		if len(ids) > 0 {
+			add(link.Platform, ids...)
+		}
+	}
+	if colls != 1 {
+		collName = ""
+	}
+	return groups, collName
+}
+
+// parseItems resolves ids into downloadable items, reporting the ones that
+// can't be parsed instead of aborting the whole run.
+func parseItems(ctx context.Context, th *tunehub.Client, apiKey, platform, quality string, ids []string, fails *failures) []tunehub.ParseItem {
+	var items []tunehub.ParseItem
+	th.ParseChunks(ctx, apiKey, platform, ids, quality, func(chunk []string, pd tunehub.ParseData, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parse: %s\n", describe(err))
+			fails.addN(len(chunk), err)
+			return
+		}
+		for _, pi := range pd.Data {
+			if !pi.Success {
+				errText := strings.TrimSpace(pi.Error)
+				if errText == "" {
+					errText = "parse failed"
+				}
+				fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", pi.ID, errText)
+				fails.add(errors.New(errText))
+				continue
+			}
+			if err := th.AttachLyrics(ctx, platform, &pi); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %s: lyrics: %s\n", pi.ID, describe(err))
+			}
+			items = append(items, pi)
+		}
+	})
+	return items
+}
+
+func printBatchResult(br download.BatchResult) {
+	name := fmt.Sprintf("%s - %s [%s]", br.Item.Info.Artist, br.Item.Info.Name, br.Item.ID)
+	if br.Err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", name, describe(br.Err))
+		return
+	}
+	fmt.Printf("ok   %s -> %s\n", name, br.Result.Dir)
+	for _, w := range br.Result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+}
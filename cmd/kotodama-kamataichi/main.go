@@ -4,35 +4,70 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 
 	"kotodama-kamataichi/internal/download"
 	"kotodama-kamataichi/internal/jsbox"
 	"kotodama-kamataichi/internal/tui"
-	"kotodama-kamataichi/internal/tunehub"
 )
 
+// Staging dirs older than this are leftovers from a crashed or killed run.
+const staleStagingAge = 24 * time.Hour
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "js-sandbox" {
-		os.Exit(jsbox.RunSandbox())
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "js-sandbox":
+			os.Exit(jsbox.RunSandbox())
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "get":
+			os.Exit(runGet(os.Args[2:]))
+		case "sync":
+			os.Exit(runSync(os.Args[2:]))
+		case "usage":
+			os.Exit(runUsage(os.Args[2:]))
+		case "retag":
+			os.Exit(runRetag(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "pins":
+			os.Exit(runPins(os.Args[2:]))
+		}
 	}
 
-	outDir := flag.String("output", "downloads", "download output directory")
+	opts := newOptions(flag.CommandLine)
 	flag.Parse()
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer opts.close()
 
-	jsr, err := jsbox.NewRunner()
+	th, err := opts.newClient()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		printErr(err)
 		os.Exit(1)
 	}
-	th := tunehub.New(jsr)
-	dl := download.NewDownloader()
+	dl, closeDL, err := opts.newDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer closeDL()
+
+	if err := download.CleanStaging(opts.outDir, staleStagingAge); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: cleaning staging dir:", err)
+	}
 
-	m := tui.New(th, dl, *outDir)
+	m := tui.New(th, dl, opts.outDir, opts.playlistFmt, opts.ledger)
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		closeDL()
+		opts.close()
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"kotodama-kamataichi/internal/config"
+	"kotodama-kamataichi/internal/cover"
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/hooks"
+	"kotodama-kamataichi/internal/jsbox"
+	"kotodama-kamataichi/internal/netroute"
+	"kotodama-kamataichi/internal/playlist"
+	"kotodama-kamataichi/internal/trace"
+	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
+)
+
+// options holds the flags shared by the TUI and the headless commands.
+type options struct {
+	fs *flag.FlagSet
+
+	configPath    string
+	outDir        string
+	mergeLyrics   bool
+	coverMax      int
+	keepCover     bool
+	verifyMD5     bool
+	segments      int
+	concurrency   int
+	limit         string
+	limitSchedule string
+	playlistFmt   string
+	noCache       bool
+	dailyBudget   float64
+	batchBudget   float64
+	tracePath     string
+
+	cfg    config.Config
+	ledger *usage.Ledger
+	tracer *trace.Tracer
+}
+
+func newOptions(fs *flag.FlagSet) *options {
+	o := &options{fs: fs}
+	fs.StringVar(&o.configPath, "config", config.DefaultPath(), "config file")
+	fs.StringVar(&o.outDir, "output", "downloads", "download output directory")
+	fs.BoolVar(&o.mergeLyrics, "bilingual-lyrics", false, "interleave translated lyrics into the main .lrc and lyrics tag")
+	fs.IntVar(&o.coverMax, "cover-max-size", cover.DefaultMaxSize, "max edge in pixels of the embedded cover (0 = original size)")
+	fs.BoolVar(&o.keepCover, "keep-original-cover", false, "keep the original cover next to the resized one")
+	fs.BoolVar(&o.verifyMD5, "verify-md5", false, "decode FLAC downloads and check the STREAMINFO MD5")
+	fs.IntVar(&o.segments, "segments", 1, "parallel range requests per audio download (1 = single stream)")
+	fs.IntVar(&o.concurrency, "concurrency", 3, "songs downloaded at once in a batch")
+	fs.StringVar(&o.limit, "limit", "", "bandwidth limit shared by all downloads, e.g. 2MB (empty = unlimited)")
+	fs.StringVar(&o.limitSchedule, "limit-schedule", "", "time-of-day limits, e.g. 07:00-22:00=2MB,22:00-07:00=0")
+	fs.StringVar(&o.playlistFmt, "playlist-format", "", "write an m3u8 or xspf playlist for each batch")
+	fs.BoolVar(&o.noCache, "no-cache", false, "bypass the search result cache")
+	fs.Float64Var(&o.dailyBudget, "daily-budget", 0, "stop parsing once today's parse cost reaches this (0 = no limit)")
+	fs.Float64Var(&o.batchBudget, "batch-budget", 0, "stop parsing once a batch's parse cost reaches this (0 = no limit)")
+	fs.StringVar(&o.tracePath, "trace", os.Getenv(trace.EnvVar), "append a redacted JSONL trace of HTTP requests and jsbox runs to this file (default $"+trace.EnvVar+")")
+	return o
+}
+
+// load reads the config file and fills in every option that wasn't given
+// explicitly on the command line. Call after fs.Parse.
+func (o *options) load() error {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		return err
+	}
+	o.cfg = cfg
+
+	set := map[string]bool{}
+	o.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	d := cfg.Download
+	if d.Output != "" && !set["output"] {
+		o.outDir = d.Output
+	}
+	if d.BilingualLyrics != nil && !set["bilingual-lyrics"] {
+		o.mergeLyrics = *d.BilingualLyrics
+	}
+	if d.CoverMaxSize != nil && !set["cover-max-size"] {
+		o.coverMax = *d.CoverMaxSize
+	}
+	if d.KeepOriginalCover != nil && !set["keep-original-cover"] {
+		o.keepCover = *d.KeepOriginalCover
+	}
+	if d.VerifyMD5 != nil && !set["verify-md5"] {
+		o.verifyMD5 = *d.VerifyMD5
+	}
+	if d.Segments != nil && !set["segments"] {
+		o.segments = *d.Segments
+	}
+	if d.Concurrency != nil && !set["concurrency"] {
+		o.concurrency = *d.Concurrency
+	}
+	if d.Limit != "" && !set["limit"] {
+		o.limit = d.Limit
+	}
+	if d.LimitSchedule != "" && !set["limit-schedule"] {
+		o.limitSchedule = d.LimitSchedule
+	}
+	if d.PlaylistFormat != "" && !set["playlist-format"] {
+		o.playlistFmt = d.PlaylistFormat
+	}
+	if u := cfg.Usage; u.DailyBudget != 0 && !set["daily-budget"] {
+		o.dailyBudget = u.DailyBudget
+	}
+	if u := cfg.Usage; u.BatchBudget != 0 && !set["batch-budget"] {
+		o.batchBudget = u.BatchBudget
+	}
+	switch o.playlistFmt {
+	case "", playlist.FormatM3U8, playlist.FormatXSPF:
+	default:
+		return fmt.Errorf("unknown playlist format %q (want m3u8 or xspf)", o.playlistFmt)
+	}
+	if o.tracePath != "" {
+		if o.tracer, err = trace.Open(o.tracePath); err != nil {
+			return fmt.Errorf("trace: %w", err)
+		}
+	}
+	return nil
+}
+
+// close releases what load opened, i.e. the trace file. Call it when the
+// command is done.
+func (o *options) close() {
+	if o.tracer != nil {
+		_ = o.tracer.Close()
+	}
+}
+
+func (o *options) newClient() (*tunehub.Client, error) {
+	jsr, err := jsbox.NewRunner()
+	if err != nil {
+		return nil, err
+	}
+	th := tunehub.New(jsr)
+	if th.Routes, err = o.newRouter(tunehub.DefaultTimeout); err != nil {
+		return nil, err
+	}
+	if o.tracer != nil {
+		jsr.Trace = o.tracer.JSBox
+		th.HTTP.Transport = o.tracer.Wrap("tunehub", th.HTTP.Transport)
+		th.Routes.Wrap = o.wrapper("tunehub")
+	}
+	th.Warn = func(msg string) { fmt.Fprintln(os.Stderr, "warning:", msg) }
+
+	// Without a cache dir both caches still work for the current run.
+	dir, _ := config.CacheDir()
+	c := o.cfg.Cache
+	ttl, _ := config.Duration(c.MethodTTL, tunehub.DefaultMethodTTL)
+	if ttl > 0 {
+		th.Methods = tunehub.NewMethodCache(dir, ttl)
+	}
+	ttl, _ = config.Duration(c.SearchTTL, tunehub.DefaultSearchTTL)
+	if ttl > 0 && !o.noCache {
+		searchDir := ""
+		if c.PersistSearch {
+			searchDir = dir
+		}
+		th.Searches = tunehub.NewSearchCache(ttl, c.SearchEntries, searchDir)
+	}
+	th.Parses = o.newParseCache()
+	th.Overrides = tunehub.LoadOverrides(methodsDir(o.cfg))
+	if mode := o.cfg.Pins.Mode; mode == "warn" || mode == "enforce" {
+		if th.Pins, err = tunehub.LoadPins(pinsPath(o.cfg), mode == "enforce"); err != nil {
+			return nil, err
+		}
+	}
+
+	o.ledger = &usage.Ledger{
+		Path:        ledgerPath(o.cfg),
+		User:        o.cfg.Usage.User,
+		DailyBudget: o.dailyBudget,
+		BatchBudget: o.batchBudget,
+	}
+	if o.ledger.User == "" {
+		if u, err := user.Current(); err == nil {
+			o.ledger.User = u.Username
+		}
+	}
+	th.Meter = o.ledger
+	return th, nil
+}
+
+// newRouter builds the routes from the config: its own rules first, then
+// the built-in ones, plus the per-platform proxies.
+func (o *options) newRouter(timeout time.Duration) (*netroute.Router, error) {
+	rules := o.cfg.Routes
+	if !o.cfg.NoDefaultRoutes {
+		rules = append(rules[:len(rules):len(rules)], netroute.Defaults()...)
+	}
+	rt, err := netroute.New(rules, timeout)
+	if err != nil {
+		return nil, err
+	}
+	for platform, proxy := range o.cfg.Proxies {
+		if err := rt.SetPlatformProxy(platform, proxy); err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+func (o *options) wrapper(source string) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper { return o.tracer.Wrap(source, rt) }
+}
+
+// newParseCache returns nil when the config turns the parse cache off.
+func (o *options) newParseCache() *tunehub.ParseCache {
+	ttl, _ := config.Duration(o.cfg.Cache.ParseMetaTTL, tunehub.DefaultMetaTTL)
+	if ttl <= 0 {
+		return nil
+	}
+	dir, _ := config.CacheDir()
+	return tunehub.NewParseCache(dir, ttl)
+}
+
+// methodsDir is empty when there is no config dir, which leaves the client
+// with TuneHub's configs only.
+func methodsDir(cfg config.Config) string {
+	if cfg.MethodsDir != "" {
+		return cfg.MethodsDir
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "methods")
+}
+
+func pinsPath(cfg config.Config) string {
+	if cfg.Pins.File != "" {
+		return cfg.Pins.File
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pins.json")
+}
+
+// warnOverrides reports the local method configs that were skipped.
+func warnOverrides(th *tunehub.Client) {
+	for _, err := range th.Overrides.Errs {
+		fmt.Fprintln(os.Stderr, "warning: method config:", err)
+	}
+}
+
+// ledgerPath is empty when there is no config dir; the ledger then only
+// keeps count in memory.
+func ledgerPath(cfg config.Config) string {
+	if cfg.Usage.Ledger != "" {
+		return cfg.Usage.Ledger
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "usage.jsonl")
+}
+
+// newDownloader builds the Downloader with hooks attached. Call the returned
+// func when done to close the hook log.
+func (o *options) newDownloader() (*download.Downloader, func(), error) {
+	dl := download.NewDownloader()
+	dl.MergeLyrics = o.mergeLyrics
+	dl.CoverMaxSize = o.coverMax
+	dl.KeepOriginalCover = o.keepCover
+	dl.VerifyMD5 = o.verifyMD5
+	dl.Segments = o.segments
+	dl.Concurrency = o.concurrency
+	// Audio and covers get no whole-request timeout: a rate-limited FLAC can
+	// take minutes. The Downloader's stall timeout catches dead transfers.
+	routes, err := o.newRouter(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	dl.Routes = routes
+	if o.tracer != nil {
+		dl.HTTP.Transport = o.tracer.Wrap("download", dl.HTTP.Transport)
+		dl.Routes.Wrap = o.wrapper("download")
+	}
+
+	rate, err := download.ParseRate(o.limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	schedule, err := download.ParseSchedule(o.limitSchedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	dl.Limiter.SetBase(rate)
+	dl.Limiter.SetSchedule(schedule)
+
+	if len(o.cfg.Hooks) == 0 {
+		return dl, func() {}, nil
+	}
+	logFile, err := o.openHookLog()
+	if err != nil {
+		return nil, nil, err
+	}
+	runner, err := hooks.New(o.cfg.Hooks, logFile)
+	if err != nil {
+		_ = logFile.Close()
+		return nil, nil, err
+	}
+	dl.AfterSong = runner.AfterSong
+	dl.AfterBatch = runner.AfterBatch
+	return dl, func() { _ = logFile.Close() }, nil
+}
+
+func (o *options) openHookLog() (*os.File, error) {
+	p := o.cfg.HookLog
+	if p == "" {
+		dir, err := config.CacheDir()
+		if err != nil {
+			return nil, err
+		}
+		p = filepath.Join(dir, "hooks.log")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+}
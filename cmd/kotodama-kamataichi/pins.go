@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+const pinsUsage = "usage: kotodama-kamataichi pins list|diff|accept [flags] [PLATFORM/FUNCTION...]"
+
+// runPins reviews TuneHub's method configs against the pinned ones. diff
+// exits 1 when any config differs from its pin, so it can gate a script.
+func runPins(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, pinsUsage)
+		return 2
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("pins "+action, flag.ContinueOnError)
+	opts := newOptions(flags)
+	all := flags.Bool("all", false, "accept: pin every config TuneHub lists")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer opts.close()
+	path := pinsPath(opts.cfg)
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "no pin file: set pins.file in the config")
+		return 1
+	}
+	pins, err := tunehub.LoadPins(path, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if action == "list" {
+		return listPins(pins)
+	}
+	if action != "diff" && action != "accept" {
+		fmt.Fprintln(os.Stderr, pinsUsage)
+		return 2
+	}
+
+	th := tunehub.New(nil)
+	if th.Routes, err = opts.newRouter(tunehub.DefaultTimeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	keys := flags.Args()
+	if len(keys) == 0 {
+		if action == "accept" && !*all {
+			fmt.Fprintln(os.Stderr, "pins accept: name the configs to accept, or use -all")
+			return 2
+		}
+		if keys, err = pinTargets(ctx, th, pins); err != nil {
+			printErr(err)
+			return exitCode(err)
+		}
+	}
+
+	var fails failures
+	differ := false
+	for _, key := range keys {
+		platform, function, ok := strings.Cut(key, "/")
+		if !ok || platform == "" || function == "" {
+			fmt.Fprintf(os.Stderr, "%s: want PLATFORM/FUNCTION\n", key)
+			return 2
+		}
+		cfg, err := th.RemoteMethodConfig(ctx, platform, function)
+		if err != nil {
+			printErr(err)
+			fails.add(err)
+			continue
+		}
+		pin, pinned := pins.Get(platform, function)
+		hash := tunehub.MethodHash(cfg)
+		switch {
+		case !pinned:
+			fmt.Printf("%s: not pinned (%s)\n", key, hash)
+		case pin.Hash == hash:
+			fmt.Printf("%s: unchanged\n", key)
+		default:
+			fmt.Printf("%s: changed since %s (%s -> %s)\n", key, pin.AcceptedAt.Local().Format("2006-01-02 15:04"), pin.Hash, hash)
+		}
+		if !pinned || pin.Hash != hash {
+			differ = true
+			for _, line := range tunehub.DiffMethods(pin.Config, cfg) {
+				fmt.Println("  " + line)
+			}
+		}
+		if action == "accept" && (!pinned || pin.Hash != hash) {
+			if err := pins.Accept(platform, function, cfg); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			fmt.Printf("%s: pinned %s\n", key, hash)
+		}
+	}
+	if code := fails.exit(); code != 0 {
+		return code
+	}
+	if action == "diff" && differ {
+		return 1
+	}
+	return 0
+}
+
+// pinTargets is every pinned config plus every config TuneHub lists.
+func pinTargets(ctx context.Context, th *tunehub.Client, pins *tunehub.Pins) ([]string, error) {
+	methods, err := th.GetMethods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	keys := pins.Keys()
+	for _, k := range keys {
+		seen[k] = true
+	}
+	for platform, functions := range methods {
+		for _, f := range functions {
+			if k := platform + "/" + f; !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func listPins(pins *tunehub.Pins) int {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONFIG\tHASH\tACCEPTED")
+	for _, k := range pins.Keys() {
+		platform, function, _ := strings.Cut(k, "/")
+		pin, _ := pins.Get(platform, function)
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", k, pin.Hash, pin.AcceptedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if err := tw.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
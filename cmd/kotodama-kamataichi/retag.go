@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/plsync"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+// runRetag rewrites tags from the parse cache without touching the network.
+func runRetag(args []string) int {
+	flags := flag.NewFlagSet("retag", flag.ContinueOnError)
+	opts := newOptions(flags)
+	platform := flags.String("platform", "netease", "platform of songs whose meta.json doesn't record one")
+	quiet := flags.Bool("q", false, "only print failures")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer opts.close()
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{opts.outDir}
+	}
+	dl, closeDL, err := opts.newDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer closeDL()
+	pc := opts.newParseCache()
+
+	var done, stale, failed int
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == download.StagingDirName || d.Name() == plsync.TrashDirName {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Name() != "meta.json" {
+				return nil
+			}
+			dir := filepath.Dir(p)
+			item, err := download.ReadMeta(dir)
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", dir, err)
+				return nil
+			}
+			if merged, ok := mergeCachedMeta(pc, *platform, item); ok {
+				item = merged
+			} else {
+				stale++
+				fmt.Fprintf(os.Stderr, "warning: %s: no cached metadata, using meta.json\n", dir)
+			}
+			if _, err := dl.Retag(dir, item); err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", dir, err)
+				return nil
+			}
+			done++
+			if !*quiet {
+				fmt.Println("ok  ", dir)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	fmt.Printf("%d retagged (%d from meta.json only), %d failed\n", done, stale, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// mergeCachedMeta overlays the cached song info and lyrics on item.
+func mergeCachedMeta(pc *tunehub.ParseCache, defPlatform string, item tunehub.ParseItem) (tunehub.ParseItem, bool) {
+	if pc == nil {
+		return item, false
+	}
+	platform := item.Platform
+	if platform == "" {
+		platform = defPlatform
+	}
+	meta, ok := pc.Meta(platform, item.ID)
+	if !ok {
+		return item, false
+	}
+	if strings.TrimSpace(meta.Info.Name) != "" {
+		item.Info = meta.Info
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&item.Cover, &meta.Cover},
+		{&item.Lyrics, &meta.Lyrics},
+		{&item.TransLyrics, &meta.TransLyrics},
+		{&item.RomaLyrics, &meta.RomaLyrics},
+	} {
+		if strings.TrimSpace(*f.src) != "" {
+			*f.dst = *f.src
+		}
+	}
+	item.Platform = platform
+	return item, true
+}
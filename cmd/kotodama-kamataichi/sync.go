@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/plsync"
+	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
+)
+
+func runSync(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	opts := newOptions(flags)
+	platform := flags.String("platform", "netease", "platform of a bare playlist ID")
+	kind := flags.String("kind", tunehub.KindPlaylist, "what a bare ID refers to: playlist, album or artist")
+	quality := flags.String("quality", "320k", "requested quality")
+	apiKey := flags.String("key", os.Getenv("TUNEHUB_API_KEY"), "TuneHub API key (default $TUNEHUB_API_KEY)")
+	prune := flags.Bool("prune", false, "move tracks removed from the playlist to "+plsync.TrashDirName)
+	playlistName := flags.String("playlist-name", "", "playlist file name without extension (default: the playlist's name)")
+	quiet := flags.Bool("q", false, "only print the summary")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer opts.close()
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: kotodama-kamataichi sync [flags] PLAYLIST-ID|URL")
+		return 2
+	}
+
+	th, err := opts.newClient()
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+	warnOverrides(th)
+	dl, closeDL, err := opts.newDownloader()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer closeDL()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ref := flags.Arg(0)
+	link := tunehub.Link{Platform: *platform, Kind: *kind, ID: ref}
+	if tunehub.LooksLikeLink(ref) {
+		if link, err = th.ResolveLink(ctx, ref); err != nil {
+			printErr(err)
+			return exitCode(err)
+		}
+	}
+
+	ctx = usage.WithBatch(ctx, "sync "+link.Platform+"/"+link.Kind+"/"+link.ID)
+
+	if err := download.CleanStaging(opts.outDir, staleStagingAge); err != nil {
+		fmt.Fprintln(os.Stderr, "warning: cleaning staging dir:", err)
+	}
+
+	s := &plsync.Syncer{
+		TH:             th,
+		DL:             dl,
+		APIKey:         *apiKey,
+		Quality:        *quality,
+		Prune:          *prune,
+		PlaylistFormat: opts.playlistFmt,
+		PlaylistName:   *playlistName,
+	}
+	if !*quiet {
+		s.Log = os.Stdout
+	}
+	rep, err := s.Run(ctx, link, opts.outDir)
+	var fails failures
+	for _, f := range rep.Failed {
+		fmt.Fprintln(os.Stderr, "FAIL", describe(f))
+		fails.add(f)
+	}
+	if err != nil {
+		printErr(err)
+		return exitCode(err)
+	}
+	fmt.Printf("%s: %d tracks, %d added, %d removed, %d trashed, %d failed\n",
+		rep.Name, rep.Total, rep.Added, rep.Removed, rep.Trashed, len(rep.Failed))
+	if rep.Playlist != "" {
+		fmt.Println("playlist:", rep.Playlist)
+	}
+	return fails.exit()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"kotodama-kamataichi/internal/config"
+	"kotodama-kamataichi/internal/usage"
+)
+
+func runUsage(args []string) int {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	configPath := flags.String("config", config.DefaultPath(), "config file")
+	days := flags.Int("days", 7, "report the last N days, today included (0 = everything)")
+	by := flags.String("by", usage.ByDay, "group by day, batch, platform or user")
+	who := flags.String("user", "", "only count parses made by this user")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	path := ledgerPath(cfg)
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "no ledger: set usage.ledger in the config")
+		return 1
+	}
+	recs, err := usage.Read(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *who != "" {
+		kept := recs[:0]
+		for _, r := range recs {
+			if r.User == *who {
+				kept = append(kept, r)
+			}
+		}
+		recs = kept
+	}
+
+	var since time.Time
+	if *days > 0 {
+		y, m, d := time.Now().Date()
+		since = time.Date(y, m, d-(*days-1), 0, 0, 0, 0, time.Local)
+	}
+	rows, err := usage.Summarize(recs, since, *by)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tcalls\ttracks\tcache hits\tcost\n", *by)
+	var total usage.Summary
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%g\n", r.Key, r.Calls, r.Tracks, r.CacheHits, r.Cost)
+		total.Calls += r.Calls
+		total.Tracks += r.Tracks
+		total.CacheHits += r.CacheHits
+		total.Cost += r.Cost
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%g\n", total.Calls, total.Tracks, total.CacheHits, total.Cost)
+	if err := tw.Flush(); err != nil {
+		return 1
+	}
+
+	l := &usage.Ledger{Path: path}
+	line := fmt.Sprintf("today: %g", l.Today())
+	if b := cfg.Usage.DailyBudget; b > 0 {
+		line += fmt.Sprintf(" of %g daily budget", b)
+	}
+	fmt.Println(line)
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/plsync"
+)
+
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	checkMD5 := flags.Bool("md5", false, "decode FLAC files and check the STREAMINFO MD5")
+	quiet := flags.Bool("q", false, "only print failures")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"downloads"}
+	}
+
+	opt := download.VerifyOptions{CheckMD5: *checkMD5}
+	var checked, failed int
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == download.StagingDirName || d.Name() == plsync.TrashDirName {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(p)) {
+			case ".flac", ".mp3":
+			default:
+				return nil
+			}
+			checked++
+			if err := download.VerifyAudio(p, opt); err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %v\n", p, err)
+			} else if !*quiet {
+				fmt.Printf("ok   %s\n", p)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	fmt.Printf("%d checked, %d failed\n", checked, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -11,24 +11,25 @@ type Metadata struct {
 	Artist string
 	Album  string
 	Lyrics string
+	Cover  *Picture
 }
 
-func TagAudio(audioPath, coverPath string, meta Metadata) error {
+type Picture struct {
+	Data   []byte
+	MIME   string
+	Width  int
+	Height int
+	Depth  int
+	Colors int
+}
+
+func TagAudio(audioPath string, meta Metadata) error {
 	switch strings.ToLower(filepath.Ext(audioPath)) {
 	case ".mp3":
-		return tagMP3(audioPath, coverPath, meta)
+		return tagMP3(audioPath, meta)
 	case ".flac":
-		return tagFLAC(audioPath, coverPath, meta)
+		return tagFLAC(audioPath, meta)
 	default:
 		return fmt.Errorf("unsupported audio format: %s", filepath.Ext(audioPath))
 	}
 }
-
-func mimeFromExt(path string) string {
-	switch strings.ToLower(filepath.Ext(path)) {
-	case ".png":
-		return "image/png"
-	default:
-		return "image/jpeg"
-	}
-}
@@ -1,14 +1,12 @@
 package audiotag
 
 import (
-	"os"
-
-	flac "github.com/go-flac/go-flac/v2"
 	"github.com/go-flac/flacpicture/v2"
 	"github.com/go-flac/flacvorbis/v2"
+	flac "github.com/go-flac/go-flac/v2"
 )
 
-func tagFLAC(audioPath, coverPath string, meta Metadata) error {
+func tagFLAC(audioPath string, meta Metadata) error {
 	f, err := flac.ParseFile(audioPath)
 	if err != nil {
 		return err
@@ -45,18 +43,38 @@ func tagFLAC(audioPath, coverPath string, meta Metadata) error {
 		f.Meta = append(f.Meta, &cmtBlock)
 	}
 
-	if coverPath != "" {
-		mime := mimeFromExt(coverPath)
-		if mime == "image/jpeg" || mime == "image/png" {
-			imgData, err := os.ReadFile(coverPath)
-			if err == nil {
-				pic, err := flacpicture.NewFromImageData(flacpicture.PictureTypeFrontCover, "Cover", imgData, mime)
-				if err == nil {
-					picBlock := pic.Marshal()
-					f.Meta = append(f.Meta, &picBlock)
+	if pic := meta.Cover; pic != nil && len(pic.Data) > 0 {
+		// Only the front cover is ours; artist or back images the user
+		// added stay.
+		kept := f.Meta[:0]
+		for _, m := range f.Meta {
+			if m.Type == flac.Picture {
+				old, err := flacpicture.ParseFromMetaDataBlock(*m)
+				if err == nil && old.PictureType == flacpicture.PictureTypeFrontCover {
+					continue
 				}
 			}
+			kept = append(kept, m)
+		}
+		f.Meta = kept
+
+		depth := pic.Depth
+		if depth <= 0 {
+			depth = 24
+		}
+
+		block := &flacpicture.MetadataBlockPicture{
+			PictureType:       flacpicture.PictureTypeFrontCover,
+			MIME:              pic.MIME,
+			Description:       "Cover",
+			Width:             uint32(pic.Width),
+			Height:            uint32(pic.Height),
+			ColorDepth:        uint32(depth),
+			IndexedColorCount: uint32(pic.Colors),
+			ImageData:         pic.Data,
 		}
+		picBlock := block.Marshal()
+		f.Meta = append(f.Meta, &picBlock)
 	}
 
 	return f.Save(audioPath)
@@ -1,12 +1,8 @@
 package audiotag
 
-import (
-	"os"
+import "github.com/bogem/id3v2/v2"
 
-	"github.com/bogem/id3v2/v2"
-)
-
-func tagMP3(audioPath, coverPath string, meta Metadata) error {
+func tagMP3(audioPath string, meta Metadata) error {
 	tag, err := id3v2.Open(audioPath, id3v2.Options{Parse: false})
 	if err != nil {
 		return err
@@ -27,17 +23,14 @@ func tagMP3(audioPath, coverPath string, meta Metadata) error {
 		})
 	}
 
-	if coverPath != "" {
-		pic, err := os.ReadFile(coverPath)
-		if err == nil {
-			tag.AddAttachedPicture(id3v2.PictureFrame{
-				Encoding:    id3v2.EncodingUTF8,
-				MimeType:    mimeFromExt(coverPath),
-				PictureType: id3v2.PTFrontCover,
-				Description: "Cover",
-				Picture:     pic,
-			})
-		}
+	if pic := meta.Cover; pic != nil && len(pic.Data) > 0 {
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			MimeType:    pic.MIME,
+			PictureType: id3v2.PTFrontCover,
+			Description: "Cover",
+			Picture:     pic.Data,
+		})
 	}
 
 	return tag.Save()
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kotodama-kamataichi/internal/hooks"
+	"kotodama-kamataichi/internal/netroute"
+)
+
+const appName = "kotodama-kamataichi"
+
+type Config struct {
+	Download Download     `json:"download"`
+	Cache    Cache        `json:"cache"`
+	Usage    Usage        `json:"usage"`
+	Hooks    []hooks.Hook `json:"hooks,omitempty"`
+	// HookLog is where hook output is appended; defaults to hooks.log in the
+	// user cache dir.
+	HookLog string `json:"hookLog,omitempty"`
+	// Routes are checked before the built-in per-platform routes unless
+	// NoDefaultRoutes drops those.
+	Routes          []netroute.Rule `json:"routes,omitempty"`
+	NoDefaultRoutes bool            `json:"noDefaultRoutes,omitempty"`
+	// Proxies maps a platform to the proxy (http://, https://, socks5://
+	// with optional user:pass@, or "direct") used for its API calls.
+	// TuneHub and download CDNs only follow Routes.
+	Proxies map[string]string `json:"proxies,omitempty"`
+	// MethodsDir holds local method configs as <platform>/<function>.json;
+	// defaults to methods in the config dir.
+	MethodsDir string `json:"methodsDir,omitempty"`
+	Pins       Pins   `json:"pins,omitempty"`
+}
+
+// Pins checks TuneHub's method configs against the hashes accepted with
+// the pins command.
+type Pins struct {
+	// Mode is "off" (the default), "warn" or "enforce".
+	Mode string `json:"mode,omitempty"`
+	// File defaults to pins.json in the config dir.
+	File string `json:"file,omitempty"`
+}
+
+// Download mirrors the command-line download flags. Unset fields keep the
+// flag defaults; flags given explicitly win over the file.
+type Download struct {
+	Output            string `json:"output,omitempty"`
+	BilingualLyrics   *bool  `json:"bilingualLyrics,omitempty"`
+	CoverMaxSize      *int   `json:"coverMaxSize,omitempty"`
+	KeepOriginalCover *bool  `json:"keepOriginalCover,omitempty"`
+	VerifyMD5         *bool  `json:"verifyMD5,omitempty"`
+	Segments          *int   `json:"segments,omitempty"`
+	Concurrency       *int   `json:"concurrency,omitempty"`
+	Limit             string `json:"limit,omitempty"`
+	LimitSchedule     string `json:"limitSchedule,omitempty"`
+	// PlaylistFormat ("m3u8" or "xspf") writes a playlist for every batch.
+	PlaylistFormat string `json:"playlistFormat,omitempty"`
+}
+
+type Cache struct {
+	// MethodTTL is how long a method config is used before it is
+	// revalidated, e.g. "6h". "0" turns the cache off.
+	MethodTTL string `json:"methodTTL,omitempty"`
+	// SearchTTL is how long search results count as fresh; "0" turns the
+	// search cache off.
+	SearchTTL     string `json:"searchTTL,omitempty"`
+	SearchEntries int    `json:"searchEntries,omitempty"`
+	// PersistSearch keeps the search cache in the user cache dir.
+	PersistSearch bool `json:"persistSearch,omitempty"`
+	// ParseMetaTTL is how long parsed song info and lyrics are kept for reuse
+	// and retagging, e.g. "720h"; "0" turns the parse cache off. Download
+	// URLs are only reused until they expire.
+	ParseMetaTTL string `json:"parseMetaTTL,omitempty"`
+}
+
+type Usage struct {
+	// Ledger is the JSONL file parse costs are appended to; defaults to
+	// usage.jsonl in the config dir.
+	Ledger string `json:"ledger,omitempty"`
+	// User tags ledger records; defaults to the login name.
+	User string `json:"user,omitempty"`
+	// DailyBudget and BatchBudget stop parsing once that much cost has been
+	// spent today or in the current batch. 0 means no limit.
+	DailyBudget float64 `json:"dailyBudget,omitempty"`
+	BatchBudget float64 `json:"batchBudget,omitempty"`
+}
+
+// Duration parses s as a time.Duration, returning def when s is empty.
+func Duration(s string, def time.Duration) (time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return def, nil
+	}
+	return time.ParseDuration(strings.TrimSpace(s))
+}
+
+func Dir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, appName), nil
+}
+
+func CacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, appName), nil
+}
+
+func DefaultPath() string {
+	dir, err := Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// Load reads the config at path. A missing file yields the zero Config.
+func Load(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	if _, err := Duration(cfg.Cache.MethodTTL, 0); err != nil {
+		return cfg, fmt.Errorf("config %s: cache.methodTTL: %w", path, err)
+	}
+	if _, err := Duration(cfg.Cache.SearchTTL, 0); err != nil {
+		return cfg, fmt.Errorf("config %s: cache.searchTTL: %w", path, err)
+	}
+	if _, err := Duration(cfg.Cache.ParseMetaTTL, 0); err != nil {
+		return cfg, fmt.Errorf("config %s: cache.parseMetaTTL: %w", path, err)
+	}
+	if _, err := netroute.New(cfg.Routes, 0); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", path, err)
+	}
+	for platform, proxy := range cfg.Proxies {
+		if err := netroute.ValidateProxy(proxy); err != nil {
+			return cfg, fmt.Errorf("config %s: proxies.%s: %w", path, platform, err)
+		}
+	}
+	switch cfg.Pins.Mode {
+	case "", "off", "warn", "enforce":
+	default:
+		return cfg, fmt.Errorf("config %s: pins.mode must be off, warn or enforce, not %q", path, cfg.Pins.Mode)
+	}
+	for _, h := range cfg.Hooks {
+		if err := h.Validate(); err != nil {
+			return cfg, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,179 @@
+package cover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	DefaultMaxSize = 1200
+	defaultQuality = 90
+)
+
+type Options struct {
+	// MaxSize caps the longest edge in pixels; <= 0 disables resizing.
+	MaxSize int
+	Quality int
+}
+
+type Image struct {
+	Data   []byte
+	MIME   string
+	Width  int
+	Height int
+	// Depth is bits per pixel; Colors is the palette size for indexed
+	// images and 0 otherwise.
+	Depth  int
+	Colors int
+}
+
+// Sniff reports the real image type from the magic bytes, or "" when it
+// isn't one we can handle.
+func Sniff(data []byte) string {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return "image/jpeg"
+	case len(data) >= 8 && bytes.Equal(data[:8], []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	default:
+		return ""
+	}
+}
+
+func ExtForMIME(mime string) string {
+	switch mime {
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// Process normalizes a downloaded cover for embedding. JPEG and PNG within
+// the size cap pass through untouched; anything else is decoded, scaled
+// down and re-encoded as JPEG.
+func Process(data []byte, opt Options) (Image, error) {
+	mime := Sniff(data)
+	if mime == "" {
+		return Image{}, errors.New("cover: unrecognized image data")
+	}
+	if opt.Quality <= 0 || opt.Quality > 100 {
+		opt.Quality = defaultQuality
+	}
+
+	cfg, err := decodeConfig(mime, data)
+	if err != nil {
+		return Image{}, fmt.Errorf("cover: %w", err)
+	}
+	fits := opt.MaxSize <= 0 || (cfg.Width <= opt.MaxSize && cfg.Height <= opt.MaxSize)
+	if fits && mime != "image/webp" {
+		depth, colors := colorDepth(cfg.ColorModel)
+		return Image{Data: data, MIME: mime, Width: cfg.Width, Height: cfg.Height, Depth: depth, Colors: colors}, nil
+	}
+
+	img, err := decode(mime, data)
+	if err != nil {
+		return Image{}, fmt.Errorf("cover: %w", err)
+	}
+	if !fits {
+		img = scaleDown(img, opt.MaxSize)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: opt.Quality}); err != nil {
+		return Image{}, fmt.Errorf("cover: %w", err)
+	}
+	b := img.Bounds()
+	return Image{Data: buf.Bytes(), MIME: "image/jpeg", Width: b.Dx(), Height: b.Dy(), Depth: 24}, nil
+}
+
+// colorDepth maps a decoder's color model to bits per pixel. The PNG decoder
+// reports truecolor without alpha as RGBA, so those count as 24 and 48.
+func colorDepth(m color.Model) (depth, colors int) {
+	switch m {
+	case color.GrayModel:
+		return 8, 0
+	case color.Gray16Model:
+		return 16, 0
+	case color.YCbCrModel, color.RGBAModel:
+		return 24, 0
+	case color.NRGBAModel, color.CMYKModel:
+		return 32, 0
+	case color.RGBA64Model:
+		return 48, 0
+	case color.NRGBA64Model:
+		return 64, 0
+	}
+	if p, ok := m.(color.Palette); ok {
+		depth = 1
+		for 1<<depth < len(p) {
+			depth++
+		}
+		return depth, len(p)
+	}
+	return 24, 0
+}
+
+func decodeConfig(mime string, data []byte) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch mime {
+	case "image/jpeg":
+		return jpeg.DecodeConfig(r)
+	case "image/png":
+		return png.DecodeConfig(r)
+	default:
+		return webp.DecodeConfig(r)
+	}
+}
+
+func decode(mime string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch mime {
+	case "image/jpeg":
+		return jpeg.Decode(r)
+	case "image/png":
+		return png.Decode(r)
+	default:
+		return webp.Decode(r)
+	}
+}
+
+func scaleDown(src image.Image, maxSize int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		h = max(1, h*maxSize/w)
+		w = maxSize
+	} else {
+		w = max(1, w*maxSize/h)
+		h = maxSize
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// flatten composites transparent images onto white; JPEG has no alpha and
+// would otherwise turn transparent areas black.
+func flatten(src image.Image) image.Image {
+	if _, ok := src.(*image.YCbCr); ok {
+		return src
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	return dst
+}
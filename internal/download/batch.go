@@ -0,0 +1,81 @@
+package download
+
+import (
+	"context"
+	"sync"
+
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+type BatchResult struct {
+	Item   tunehub.ParseItem `json:"item"`
+	Result Result            `json:"result"`
+	Err    error             `json:"-"`
+	Error  string            `json:"error,omitempty"`
+}
+
+type BatchEvent struct {
+	Index    int
+	Progress Progress
+	Done     bool
+	Result   BatchResult
+}
+
+// DownloadBatch downloads items with up to d.Concurrency songs in flight.
+// Results keep the order of items regardless of completion order.
+func (d *Downloader) DownloadBatch(ctx context.Context, rootDir string, items []tunehub.ParseItem, onEvent func(BatchEvent)) ([]BatchResult, []string, error) {
+	results := make([]BatchResult, len(items))
+	workers := min(max(d.Concurrency, 1), max(len(items), 1))
+
+	var mu sync.Mutex
+	emit := func(ev BatchEvent) {
+		if onEvent == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		onEvent(ev)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				res, err := d.DownloadSong(ctx, rootDir, items[i], func(p Progress) {
+					emit(BatchEvent{Index: i, Progress: p})
+				})
+				br := BatchResult{Item: items[i], Result: res, Err: err}
+				if err != nil {
+					br.Error = err.Error()
+				}
+				results[i] = br
+				emit(BatchEvent{Index: i, Done: true, Result: br})
+			}
+		}()
+	}
+feed:
+	for i := range items {
+		select {
+		case <-ctx.Done():
+			break feed
+		case next <- i:
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Err == nil && results[i].Result.Dir == "" {
+			results[i] = BatchResult{Item: items[i], Err: ctx.Err(), Error: "not started: " + ctx.Err().Error()}
+		}
+	}
+
+	if d.AfterBatch == nil {
+		return results, nil, nil
+	}
+	warnings, err := d.AfterBatch(ctx, results)
+	return results, warnings, err
+}
@@ -0,0 +1,52 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+
+	"kotodama-kamataichi/internal/audiotag"
+	"kotodama-kamataichi/internal/cover"
+)
+
+type coverFiles struct {
+	path     string
+	origPath string
+	pic      *audiotag.Picture
+}
+
+// finishCover turns the raw cover download into the sidecar and the picture
+// to embed. Images we can't decode are kept as a sidecar but not embedded.
+func (d *Downloader) finishCover(rawPath string) (coverFiles, error) {
+	data, err := os.ReadFile(rawPath)
+	if err != nil {
+		return coverFiles{}, err
+	}
+	dir := filepath.Dir(rawPath)
+	origMIME := cover.Sniff(data)
+
+	img, perr := cover.Process(data, cover.Options{MaxSize: d.CoverMaxSize})
+	if perr != nil {
+		p := filepath.Join(dir, "cover"+cover.ExtForMIME(origMIME))
+		if err := os.Rename(rawPath, p); err != nil {
+			return coverFiles{}, err
+		}
+		return coverFiles{path: p}, nil
+	}
+
+	out := coverFiles{
+		path: filepath.Join(dir, "cover"+cover.ExtForMIME(img.MIME)),
+		pic:  &audiotag.Picture{Data: img.Data, MIME: img.MIME, Width: img.Width, Height: img.Height, Depth: img.Depth, Colors: img.Colors},
+	}
+	unchanged := len(img.Data) == len(data) && img.MIME == origMIME
+	if unchanged {
+		return out, os.Rename(rawPath, out.path)
+	}
+	if err := os.WriteFile(out.path, img.Data, 0o644); err != nil {
+		return coverFiles{}, err
+	}
+	if d.KeepOriginalCover {
+		out.origPath = filepath.Join(dir, "cover.orig"+cover.ExtForMIME(origMIME))
+		return out, os.Rename(rawPath, out.origPath)
+	}
+	return out, os.Remove(rawPath)
+}
@@ -7,9 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -17,6 +15,9 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"kotodama-kamataichi/internal/audiotag"
+	"kotodama-kamataichi/internal/cover"
+	"kotodama-kamataichi/internal/lyrics"
+	"kotodama-kamataichi/internal/netroute"
 	"kotodama-kamataichi/internal/tunehub"
 )
 
@@ -27,19 +28,60 @@ type Progress struct {
 }
 
 type Result struct {
-	Dir        string
-	AudioPath  string
-	CoverPath  string
-	MetaPath   string
-	LyricsPath string
+	Dir             string `json:"dir"`
+	AudioPath       string `json:"audioPath"`
+	CoverPath       string `json:"coverPath,omitempty"`
+	OrigCoverPath   string `json:"origCoverPath,omitempty"`
+	MetaPath        string `json:"metaPath"`
+	LyricsPath      string `json:"lyricsPath,omitempty"`
+	TransLyricsPath string `json:"transLyricsPath,omitempty"`
+	RomaLyricsPath  string `json:"romaLyricsPath,omitempty"`
+	// Warnings are non-fatal problems, e.g. from post-download hooks.
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 type Downloader struct {
 	HTTP *http.Client
+	// Routes picks per-host transport settings; nil sends everything with
+	// HTTP.
+	Routes *netroute.Router
+	// MergeLyrics interleaves the translation into the primary .lrc sidecar
+	// and the embedded lyrics tag.
+	MergeLyrics bool
+	// CoverMaxSize caps the longest edge of the embedded cover; <= 0 embeds
+	// the image at its original size.
+	CoverMaxSize int
+	// KeepOriginalCover keeps the untouched download next to the processed
+	// cover whenever processing changed it.
+	KeepOriginalCover bool
+	// VerifyMD5 fully decodes FLAC downloads to check the STREAMINFO MD5.
+	VerifyMD5 bool
+	// Segments > 1 fetches large audio files as that many concurrent range
+	// requests when the server supports it.
+	Segments int
+	// Limiter is shared by every transfer this Downloader makes.
+	Limiter *RateLimiter
+	// StallTimeout abandons and retries a transfer once a single read from
+	// the server blocks this long; <= 0 means DefaultStallTimeout. There is
+	// no limit on a transfer as a whole, so slow downloads still finish.
+	StallTimeout time.Duration
+	// Concurrency bounds the songs DownloadBatch fetches at once.
+	Concurrency int
+
+	// AfterSong runs once a song is in place; an error marks the song failed
+	// even though its files are kept.
+	AfterSong func(ctx context.Context, res Result, item tunehub.ParseItem) (warnings []string, err error)
+	// AfterBatch runs when DownloadBatch has finished every item.
+	AfterBatch func(ctx context.Context, results []BatchResult) (warnings []string, err error)
 }
 
 func NewDownloader() *Downloader {
-	return &Downloader{HTTP: &http.Client{Timeout: 60 * time.Second}}
+	return &Downloader{
+		HTTP:         &http.Client{Transport: newTransport()},
+		CoverMaxSize: cover.DefaultMaxSize,
+		Limiter:      NewRateLimiter(0),
+		Concurrency:  3,
+	}
 }
 
 func (d *Downloader) DownloadSong(ctx context.Context, rootDir string, item tunehub.ParseItem, onProgress func(Progress)) (Result, error) {
@@ -53,38 +95,64 @@ func (d *Downloader) DownloadSong(ctx context.Context, rootDir string, item tune
 		return Result{}, errors.New("missing song url")
 	}
 
-	songDirName := sanitizeName(fmt.Sprintf("%s - %s [%s]", item.Info.Artist, item.Info.Name, item.ID))
+	songDirName := SanitizeName(fmt.Sprintf("%s - %s [%s]", item.Info.Artist, item.Info.Name, item.ID))
 	songDir := filepath.Join(rootDir, songDirName)
-	if err := os.MkdirAll(songDir, 0o755); err != nil {
+
+	// Everything is assembled in a staging dir on the same filesystem and
+	// only moved into place once audio, cover and tags are complete.
+	stage, err := newStage(rootDir)
+	if err != nil {
 		return Result{}, err
 	}
+	defer discardStage(stage)
 
-	metaPath := filepath.Join(songDir, "meta.json")
-	if err := writeJSON(metaPath, item); err != nil {
+	res, err := d.fillSongDir(ctx, stage, item, onProgress)
+	if err != nil {
 		return Result{}, err
 	}
+	if err := commitStage(stage, songDir); err != nil {
+		return Result{}, err
+	}
+	res = res.rebase(stage, songDir)
 
-	lyricsPath := ""
-	if strings.TrimSpace(item.Lyrics) != "" {
-		lyricsPath = filepath.Join(songDir, "lyrics.lrc")
-		if err := os.WriteFile(lyricsPath, []byte(item.Lyrics), 0o644); err != nil {
-			return Result{}, err
+	if d.AfterSong != nil {
+		warnings, err := d.AfterSong(ctx, res, item)
+		res.Warnings = append(res.Warnings, warnings...)
+		if err != nil {
+			return res, err
 		}
 	}
+	return res, nil
+}
+
+func (d *Downloader) fillSongDir(ctx context.Context, songDir string, item tunehub.ParseItem, onProgress func(Progress)) (Result, error) {
+	metaPath := filepath.Join(songDir, "meta.json")
+	if err := writeJSON(metaPath, item); err != nil {
+		return Result{}, err
+	}
 
 	audioExt := audioExtFromQuality(item.ActualQuality, item.Quality)
-	audioName := sanitizeName(fmt.Sprintf("%s - %s", item.Info.Artist, item.Info.Name)) + audioExt
-	audioPath := filepath.Join(songDir, audioName)
+	audioPath := filepath.Join(songDir, audioFileName(item))
 
-	var coverPath string
+	var res Result
+	lyricsText, err := d.writeLyrics(songDir, item, &res)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var coverRaw string
 	coverURL := strings.TrimSpace(item.Cover)
 	if coverURL != "" {
-		coverPath = filepath.Join(songDir, "cover"+coverExtFromURL(coverURL))
+		// The URL extension can't be trusted; the real type is sniffed later.
+		coverRaw = filepath.Join(songDir, "cover.download")
 	}
 
 	g, gctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
-		return downloadWithRetry(gctx, d.http(), item.URL, audioPath, item.FileSize, func(p Progress) {
+		check := func(part string) error {
+			return verifyAudio(part, audioExt, VerifyOptions{CheckMD5: d.VerifyMD5})
+		}
+		return d.fetchAudio(gctx, item.URL, audioPath, item.FileSize, check, func(p Progress) {
 			p.Kind = "audio"
 			if onProgress != nil {
 				onProgress(p)
@@ -93,7 +161,7 @@ func (d *Downloader) DownloadSong(ctx context.Context, rootDir string, item tune
 	})
 	if coverURL != "" {
 		g.Go(func() error {
-			return downloadWithRetry(gctx, d.http(), coverURL, coverPath, 0, func(p Progress) {
+			return d.downloadWithRetry(gctx, coverURL, coverRaw, 0, nil, func(p Progress) {
 				p.Kind = "cover"
 				if onProgress != nil {
 					onProgress(p)
@@ -106,16 +174,31 @@ func (d *Downloader) DownloadSong(ctx context.Context, rootDir string, item tune
 		return Result{}, err
 	}
 
-	if err := audiotag.TagAudio(audioPath, coverPath, audiotag.Metadata{
+	var pic *audiotag.Picture
+	if coverRaw != "" {
+		cv, err := d.finishCover(coverRaw)
+		if err != nil {
+			return Result{}, err
+		}
+		res.CoverPath = cv.path
+		res.OrigCoverPath = cv.origPath
+		pic = cv.pic
+	}
+
+	if err := audiotag.TagAudio(audioPath, audiotag.Metadata{
 		Title:  item.Info.Name,
 		Artist: item.Info.Artist,
 		Album:  item.Info.Album,
-		Lyrics: item.Lyrics,
+		Lyrics: lyricsText,
+		Cover:  pic,
 	}); err != nil {
 		return Result{}, fmt.Errorf("tag audio: %w", err)
 	}
 
-	return Result{Dir: songDir, AudioPath: audioPath, CoverPath: coverPath, MetaPath: metaPath, LyricsPath: lyricsPath}, nil
+	res.Dir = songDir
+	res.AudioPath = audioPath
+	res.MetaPath = metaPath
+	return res, nil
 }
 
 func (d *Downloader) http() *http.Client {
@@ -125,6 +208,45 @@ func (d *Downloader) http() *http.Client {
 	return http.DefaultClient
 }
 
+func (d *Downloader) do(req *http.Request) (*http.Response, error) {
+	if d.Routes != nil {
+		return d.Routes.Route(req).Do(req)
+	}
+	return d.http().Do(req)
+}
+
+// lyricsBase names the .lrc sidecars; the primary one has always been
+// lyrics.lrc, and libraries rely on that.
+const lyricsBase = "lyrics"
+
+// writeLyrics writes the .lrc sidecars next to the audio and returns the
+// text to embed in the lyrics tag.
+func (d *Downloader) writeLyrics(songDir string, item tunehub.ParseItem, res *Result) (string, error) {
+	lyricsText := item.Lyrics
+	if d.MergeLyrics {
+		lyricsText = lyrics.Merge(item.Lyrics, item.TransLyrics)
+	}
+	for _, sc := range []struct {
+		text string
+		ext  string
+		dst  *string
+	}{
+		{lyricsText, ".lrc", &res.LyricsPath},
+		{item.TransLyrics, ".trans.lrc", &res.TransLyricsPath},
+		{item.RomaLyrics, ".roma.lrc", &res.RomaLyricsPath},
+	} {
+		if strings.TrimSpace(sc.text) == "" {
+			continue
+		}
+		p := filepath.Join(songDir, lyricsBase+sc.ext)
+		if err := os.WriteFile(p, []byte(sc.text), 0o644); err != nil {
+			return "", err
+		}
+		*sc.dst = p
+	}
+	return lyricsText, nil
+}
+
 func writeJSON(p string, v any) error {
 	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
@@ -134,6 +256,10 @@ func writeJSON(p string, v any) error {
 	return os.WriteFile(p, b, 0o644)
 }
 
+func audioFileName(item tunehub.ParseItem) string {
+	return SanitizeName(fmt.Sprintf("%s - %s", item.Info.Artist, item.Info.Name)) + audioExtFromQuality(item.ActualQuality, item.Quality)
+}
+
 func audioExtFromQuality(actual, requested string) string {
 	q := strings.ToLower(strings.TrimSpace(actual))
 	if q == "" {
@@ -145,43 +271,41 @@ func audioExtFromQuality(actual, requested string) string {
 	return ".mp3"
 }
 
-func coverExtFromURL(raw string) string {
-	u, err := url.Parse(raw)
-	if err == nil {
-		ext := strings.ToLower(path.Ext(u.Path))
-		switch ext {
-		case ".jpg", ".jpeg", ".png", ".webp":
-			return ext
-		}
-	}
-	return ".jpg"
-}
-
 const (
 	maxRetries    = 3
 	retryBaseWait = time.Second
 )
 
-type httpStatusError struct {
-	code int
-}
-
-func (e *httpStatusError) Error() string {
-	return fmt.Sprintf("http %d", e.code)
-}
-
 func isRetryable(err error) bool {
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errRangeUnsupported) {
 		return false
 	}
-	var se *httpStatusError
-	if errors.As(err, &se) {
-		return se.code >= 500
+	var re interface{ Retryable() bool }
+	if errors.As(err, &re) {
+		return re.Retryable()
 	}
+	// Plain network errors.
 	return true
 }
 
-func downloadWithRetry(ctx context.Context, client *http.Client, rawURL, dst string, expectedTotal int64, progress func(Progress)) error {
+// checkSize compares the bytes received with both the Content-Length and
+// the size the parse reported, whichever are known. A complete transfer
+// that disagrees with the parse is the wrong file, not a dropped
+// connection, so retrying won't help.
+func checkSize(dst string, got, announced, expected int64) error {
+	if announced > 0 && got != announced {
+		return &SizeMismatchError{Got: got, Want: announced}
+	}
+	if expected > 0 && got != expected {
+		if announced > 0 {
+			return &IntegrityError{Path: dst, Reason: fmt.Sprintf("got %d bytes, the parse reported %d", got, expected)}
+		}
+		return &SizeMismatchError{Got: got, Want: expected}
+	}
+	return nil
+}
+
+func (d *Downloader) downloadWithRetry(ctx context.Context, rawURL, dst string, expectedTotal int64, check func(part string) error, progress func(Progress)) error {
 	var lastErr error
 	for attempt := range maxRetries {
 		if attempt > 0 {
@@ -192,7 +316,7 @@ func downloadWithRetry(ctx context.Context, client *http.Client, rawURL, dst str
 			case <-time.After(wait):
 			}
 		}
-		lastErr = downloadFile(ctx, client, rawURL, dst, expectedTotal, progress)
+		lastErr = d.downloadFile(ctx, rawURL, dst, expectedTotal, check, progress)
 		if lastErr == nil || !isRetryable(lastErr) {
 			return lastErr
 		}
@@ -200,7 +324,9 @@ func downloadWithRetry(ctx context.Context, client *http.Client, rawURL, dst str
 	return lastErr
 }
 
-func downloadFile(ctx context.Context, client *http.Client, rawURL string, dst string, expectedTotal int64, progress func(Progress)) (err error) {
+// downloadFile streams rawURL into dst via a .part file. The part is only
+// renamed into place once its size matches and check (if any) accepts it.
+func (d *Downloader) downloadFile(ctx context.Context, rawURL string, dst string, expectedTotal int64, check func(part string) error, progress func(Progress)) (err error) {
 	if strings.TrimSpace(rawURL) == "" {
 		return errors.New("missing url")
 	}
@@ -208,18 +334,21 @@ func downloadFile(ctx context.Context, client *http.Client, rawURL string, dst s
 		return errors.New("missing dst")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	watch := d.watchStall(ctx)
+	defer watch.stop()
+	req, err := http.NewRequestWithContext(watch.ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return err
 	}
-	res, err := client.Do(req)
+	res, err := d.do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return &httpStatusError{code: res.StatusCode}
+		return &HTTPError{Status: res.StatusCode}
 	}
+	body := d.Limiter.Reader(ctx, watch.reader(res.Body))
 
 	total := res.ContentLength
 	if total <= 0 && expectedTotal > 0 {
@@ -240,7 +369,7 @@ func downloadFile(ctx context.Context, client *http.Client, rawURL string, dst s
 	buf := make([]byte, 32*1024)
 	var n int64
 	for {
-		rn, rerr := res.Body.Read(buf)
+		rn, rerr := body.Read(buf)
 		if rn > 0 {
 			wn, werr := f.Write(buf[:rn])
 			if werr != nil {
@@ -255,12 +384,20 @@ func downloadFile(ctx context.Context, client *http.Client, rawURL string, dst s
 			if errors.Is(rerr, io.EOF) {
 				break
 			}
-			return rerr
+			return watch.err(rerr)
 		}
 	}
 	if cerr := f.Close(); cerr != nil {
 		return cerr
 	}
+	if err := checkSize(dst, n, res.ContentLength, expectedTotal); err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(part); err != nil {
+			return err
+		}
+	}
 	if err := os.Rename(part, dst); err != nil {
 		return err
 	}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HTTPError is a non-2xx answer from the audio or cover server.
+type HTTPError struct {
+	Status int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http %d", e.Status)
+}
+
+func (e *HTTPError) Retryable() bool {
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests
+}
+
+func (e *HTTPError) Hint() string {
+	switch {
+	case e.Status == http.StatusForbidden || e.Status == http.StatusNotFound || e.Status == http.StatusGone:
+		return "the download link has expired or was refused; search and download the song again"
+	case e.Retryable():
+		return "the file server is having trouble; try again later"
+	}
+	return ""
+}
+
+// IntegrityError means the file arrived but isn't the audio it claims to be.
+type IntegrityError struct {
+	Path   string
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	name := strings.TrimSuffix(filepath.Base(e.Path), ".part")
+	return fmt.Sprintf("integrity check failed for %s: %s", name, e.Reason)
+}
+
+func (e *IntegrityError) Retryable() bool { return false }
+
+func (e *IntegrityError) Hint() string {
+	return "the server sent a damaged or wrong file; try again later or pick another quality"
+}
+
+// SizeMismatchError means the body ended before (or after) Content-Length.
+type SizeMismatchError struct {
+	Got  int64
+	Want int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("size mismatch: got %d bytes, want %d", e.Got, e.Want)
+}
+
+func (e *SizeMismatchError) Retryable() bool { return true }
+
+func (e *SizeMismatchError) Hint() string {
+	return "the connection dropped mid-download; try again"
+}
+
+// StallError means the server stopped sending in the middle of a transfer.
+type StallError struct {
+	After time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("transfer stalled: no data for %s", e.After)
+}
+
+func (e *StallError) Retryable() bool { return true }
+
+func (e *StallError) Hint() string {
+	return "the server stopped sending data; check the connection and try again"
+}
@@ -0,0 +1,234 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reads are never split, so the bucket must hold at least one buffer.
+const minBurst = 64 * 1024
+
+// ScheduleRule applies Rate between Start and End, given as offsets from
+// local midnight. End <= Start wraps past midnight.
+type ScheduleRule struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+func (r ScheduleRule) matches(t time.Time) bool {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if r.End > r.Start {
+		return tod >= r.Start && tod < r.End
+	}
+	return tod >= r.Start || tod < r.End
+}
+
+// RateLimiter is a token bucket in bytes/sec shared across transfers. A rate
+// of 0 means unlimited. The effective rate is the runtime override if set,
+// otherwise the first matching schedule rule, otherwise the base rate.
+type RateLimiter struct {
+	mu       sync.Mutex
+	base     int64
+	override int64
+	hasOver  bool
+	schedule []ScheduleRule
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{base: max(bytesPerSec, 0), now: time.Now}
+}
+
+func (l *RateLimiter) SetBase(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.base = max(bytesPerSec, 0)
+}
+
+func (l *RateLimiter) SetSchedule(rules []ScheduleRule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = append([]ScheduleRule(nil), rules...)
+}
+
+// SetRate overrides base and schedule until ClearRate is called.
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.override = max(bytesPerSec, 0)
+	l.hasOver = true
+}
+
+func (l *RateLimiter) ClearRate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hasOver = false
+}
+
+// Rate reports the effective rate and whether it comes from an override.
+func (l *RateLimiter) Rate() (bytesPerSec int64, overridden bool) {
+	if l == nil {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rateAt(l.now()), l.hasOver
+}
+
+func (l *RateLimiter) rateAt(t time.Time) int64 {
+	if l.hasOver {
+		return l.override
+	}
+	for _, r := range l.schedule {
+		if r.matches(t) {
+			return r.Rate
+		}
+	}
+	return l.base
+}
+
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	rate := l.rateAt(now)
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+	burst := float64(max(rate, minBurst))
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*float64(rate))
+	}
+	l.last = now
+	// Go into debt and sleep it off; keeps callers in FIFO-ish order.
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *RateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.l.WaitN(lr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ParseRate parses sizes like "2MB", "512k" or "1.5M" (binary units) as
+// bytes/sec. "", "0", "off" and "unlimited" mean no limit.
+func ParseRate(raw string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	s = strings.TrimSuffix(s, "/s")
+	switch s {
+	case "", "0", "off", "none", "unlimited":
+		return 0, nil
+	}
+	mult := 1.0
+	for _, u := range []struct {
+		suffix string
+		mult   float64
+	}{
+		{"gb", 1 << 30}, {"g", 1 << 30},
+		{"mb", 1 << 20}, {"m", 1 << 20},
+		{"kb", 1 << 10}, {"k", 1 << 10},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q", raw)
+	}
+	return int64(v * mult), nil
+}
+
+// ParseSchedule parses "07:00-22:00=2MB,22:00-07:00=0".
+func ParseSchedule(s string) ([]ScheduleRule, error) {
+	var rules []ScheduleRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rate, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("schedule %q: want HH:MM-HH:MM=RATE", part)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("schedule %q: want HH:MM-HH:MM=RATE", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		r, err := ParseRate(rate)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, ScheduleRule{Start: start, End: end, Rate: r})
+	}
+	return rules, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,82 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kotodama-kamataichi/internal/audiotag"
+	"kotodama-kamataichi/internal/cover"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+// ReadMeta loads the meta.json DownloadSong left in songDir.
+func ReadMeta(songDir string) (tunehub.ParseItem, error) {
+	var item tunehub.ParseItem
+	b, err := os.ReadFile(filepath.Join(songDir, "meta.json"))
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(b, &item); err != nil {
+		return item, fmt.Errorf("meta.json: %w", err)
+	}
+	return item, nil
+}
+
+// Retag rewrites the tags, lyric sidecars and meta.json of a song dir made
+// by DownloadSong from item. It needs no network: the cover already in the
+// dir is embedded again.
+func (d *Downloader) Retag(songDir string, item tunehub.ParseItem) (Result, error) {
+	res := Result{Dir: songDir, MetaPath: filepath.Join(songDir, "meta.json")}
+	entries, err := os.ReadDir(songDir)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch ext := strings.ToLower(filepath.Ext(name)); {
+		case e.IsDir():
+		case (ext == ".flac" || ext == ".mp3") && res.AudioPath == "":
+			res.AudioPath = filepath.Join(songDir, name)
+		case strings.HasPrefix(name, "cover.") && !strings.HasPrefix(name, "cover.orig.") && ext != ".download":
+			res.CoverPath = filepath.Join(songDir, name)
+		}
+	}
+	if res.AudioPath == "" {
+		return Result{}, errors.New("no audio file in " + songDir)
+	}
+
+	lyricsText, err := d.writeLyrics(songDir, item, &res)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var pic *audiotag.Picture
+	if res.CoverPath != "" {
+		data, err := os.ReadFile(res.CoverPath)
+		if err != nil {
+			return Result{}, err
+		}
+		// The cover was processed when it was downloaded; only re-read it.
+		if img, err := cover.Process(data, cover.Options{}); err == nil {
+			pic = &audiotag.Picture{Data: img.Data, MIME: img.MIME, Width: img.Width, Height: img.Height, Depth: img.Depth, Colors: img.Colors}
+		}
+	}
+
+	if err := audiotag.TagAudio(res.AudioPath, audiotag.Metadata{
+		Title:  item.Info.Name,
+		Artist: item.Info.Artist,
+		Album:  item.Info.Album,
+		Lyrics: lyricsText,
+		Cover:  pic,
+	}); err != nil {
+		return Result{}, fmt.Errorf("tag audio: %w", err)
+	}
+	if err := writeJSON(res.MetaPath, item); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
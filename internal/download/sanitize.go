@@ -5,7 +5,7 @@ import (
 	"unicode"
 )
 
-func sanitizeName(s string) string {
+func SanitizeName(s string) string {
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return "unknown"
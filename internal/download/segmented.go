@@ -0,0 +1,232 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Files smaller than this aren't worth the extra connections.
+const minSegmentedSize = 4 * 1024 * 1024
+
+var errRangeUnsupported = errors.New("server does not support range requests")
+
+// fetchAudio downloads the song audio, split into d.Segments concurrent range
+// requests when enabled and supported, and as a single stream otherwise. A
+// segment that stalls or drops is retried on its own from where it stopped.
+func (d *Downloader) fetchAudio(ctx context.Context, rawURL, dst string, expectedTotal int64, check func(part string) error, progress func(Progress)) error {
+	if d.Segments > 1 {
+		total, err := probeRanges(ctx, d.do, rawURL)
+		if err == nil && total >= minSegmentedSize {
+			// The probe already told us the size; a file the parse didn't
+			// promise isn't worth fetching.
+			if err := checkSize(dst, total, total, expectedTotal); err != nil {
+				return err
+			}
+			err = d.downloadSegmented(ctx, rawURL, dst, total, check, progress)
+			if !errors.Is(err, errRangeUnsupported) {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return d.downloadWithRetry(ctx, rawURL, dst, expectedTotal, check, progress)
+}
+
+// probeRanges asks for the first byte and reports the full size when the
+// server answers with a proper 206.
+func probeRanges(ctx context.Context, do func(*http.Request) (*http.Response, error), rawURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	res, err := do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1024))
+
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, errRangeUnsupported
+	}
+	start, _, total, ok := parseContentRange(res.Header.Get("Content-Range"))
+	if !ok || start != 0 || total <= 0 {
+		return 0, errRangeUnsupported
+	}
+	return total, nil
+}
+
+func parseContentRange(v string) (start, end, total int64, ok bool) {
+	v, found := strings.CutPrefix(strings.TrimSpace(v), "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rng, size, found := strings.Cut(v, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	s, e, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	var err error
+	if start, err = strconv.ParseInt(s, 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+	if end, err = strconv.ParseInt(e, 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+	if size == "*" {
+		return start, end, -1, true
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+type segmentProgress struct {
+	mu       sync.Mutex
+	done     int64
+	total    int64
+	progress func(Progress)
+}
+
+func (p *segmentProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.progress != nil {
+		p.progress(Progress{Bytes: p.done, Total: p.total})
+	}
+}
+
+func (d *Downloader) downloadSegmented(ctx context.Context, rawURL, dst string, total int64, check func(part string) error, progress func(Progress)) (err error) {
+	part := dst + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(part)
+		}
+	}()
+	if err := f.Truncate(total); err != nil {
+		return err
+	}
+
+	prog := &segmentProgress{total: total, progress: progress}
+	size := (total + int64(d.Segments) - 1) / int64(d.Segments)
+	g, gctx := errgroup.WithContext(ctx)
+	for start := int64(0); start < total; start += size {
+		end := min(start+size, total) - 1
+		g.Go(func() error {
+			return d.fetchSegmentWithRetry(gctx, rawURL, f, start, end, prog)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(part); err != nil {
+			return err
+		}
+	}
+	return os.Rename(part, dst)
+}
+
+// fetchSegmentWithRetry retries a single range on its own, resuming from
+// the last byte written instead of starting the segment over.
+func (d *Downloader) fetchSegmentWithRetry(ctx context.Context, rawURL string, f *os.File, start, end int64, prog *segmentProgress) error {
+	var lastErr error
+	for attempt := range maxRetries {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return lastErr
+			case <-time.After(retryBaseWait << attempt):
+			}
+		}
+		n, err := d.fetchSegment(ctx, rawURL, f, start, end, prog)
+		start += n
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !isRetryable(err) {
+			return err
+		}
+	}
+	return lastErr
+}
+
+func (d *Downloader) fetchSegment(ctx context.Context, rawURL string, f *os.File, start, end int64, prog *segmentProgress) (int64, error) {
+	if start > end {
+		return 0, nil
+	}
+	watch := d.watchStall(ctx)
+	defer watch.stop()
+	req, err := http.NewRequestWithContext(watch.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	res, err := d.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return 0, &HTTPError{Status: res.StatusCode}
+		}
+		return 0, errRangeUnsupported
+	}
+	if s, _, _, ok := parseContentRange(res.Header.Get("Content-Range")); !ok || s != start {
+		return 0, errRangeUnsupported
+	}
+
+	body := d.Limiter.Reader(ctx, watch.reader(res.Body))
+	want := end - start + 1
+	buf := make([]byte, 64*1024)
+	var n int64
+	for n < want {
+		rn, rerr := body.Read(buf[:min(int64(len(buf)), want-n)])
+		if rn > 0 {
+			if _, werr := f.WriteAt(buf[:rn], start+n); werr != nil {
+				return n, werr
+			}
+			n += int64(rn)
+			prog.add(int64(rn))
+		}
+		if rerr != nil {
+			if errors.Is(rerr, io.EOF) {
+				break
+			}
+			return n, watch.err(rerr)
+		}
+	}
+	if n != want {
+		return n, &SizeMismatchError{Got: n, Want: want}
+	}
+	return n, nil
+}
@@ -0,0 +1,158 @@
+package download
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StagingDirName is the per-library directory holding in-progress songs.
+const StagingDirName = ".kotodama-staging"
+
+func newStage(rootDir string) (string, error) {
+	base := filepath.Join(rootDir, StagingDirName)
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(base, "song-")
+}
+
+// discardStage removes stage if it still exists (i.e. wasn't committed). The
+// shared staging dir is left to CleanStaging: removing it here would race
+// with a concurrent newStage between its MkdirAll and MkdirTemp.
+func discardStage(stage string) {
+	_ = os.RemoveAll(stage)
+}
+
+// commitStage moves a finished stage to dst. When dst already exists only
+// the files a download owns are replaced; anything else the user keeps in
+// the song dir stays. Replaced files are set aside first and put back if a
+// move fails, so dst is never left half-written.
+func commitStage(stage, dst string) error {
+	if err := os.Chmod(stage, 0o755); err != nil {
+		return err
+	}
+	_, err := os.Lstat(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(stage, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(stage)
+	if err != nil {
+		return err
+	}
+	replace := ownedFiles(dst)
+	for _, e := range entries {
+		replace[e.Name()] = true
+	}
+
+	old, err := os.MkdirTemp(filepath.Dir(stage), "old-")
+	if err != nil {
+		return err
+	}
+	var setAside, placed []string
+	rollback := func() {
+		for _, name := range placed {
+			_ = os.Rename(filepath.Join(dst, name), filepath.Join(stage, name))
+		}
+		for _, name := range setAside {
+			_ = os.Rename(filepath.Join(old, name), filepath.Join(dst, name))
+		}
+		// Only succeeds once everything is back.
+		_ = os.Remove(old)
+	}
+	for name := range replace {
+		err := os.Rename(filepath.Join(dst, name), filepath.Join(old, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			rollback()
+			return err
+		}
+		setAside = append(setAside, name)
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(stage, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			rollback()
+			return err
+		}
+		placed = append(placed, e.Name())
+	}
+	return os.RemoveAll(old)
+}
+
+// ownedFiles lists what an earlier download may have left in songDir: the
+// fixed sidecar names and the audio file its meta.json names.
+func ownedFiles(songDir string) map[string]bool {
+	owned := map[string]bool{"meta.json": true, "cover.download": true}
+	for _, ext := range []string{".lrc", ".trans.lrc", ".roma.lrc"} {
+		owned[lyricsBase+ext] = true
+	}
+	for _, ext := range []string{".jpg", ".png", ".webp"} {
+		owned["cover"+ext] = true
+		owned["cover.orig"+ext] = true
+	}
+	if item, err := ReadMeta(songDir); err == nil {
+		owned[audioFileName(item)] = true
+	}
+	return owned
+}
+
+// CleanStaging removes staging leftovers under rootDir older than maxAge,
+// e.g. from a crashed or killed run.
+func CleanStaging(rootDir string, maxAge time.Duration) error {
+	base := filepath.Join(rootDir, StagingDirName)
+	entries, err := os.ReadDir(base)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(base, e.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Only succeeds once nothing else is staging here.
+	_ = os.Remove(base)
+	return errors.Join(errs...)
+}
+
+func (r Result) rebase(from, to string) Result {
+	move := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		rel, err := filepath.Rel(from, p)
+		if err != nil {
+			return p
+		}
+		return filepath.Join(to, rel)
+	}
+	r.Dir = to
+	r.AudioPath = move(r.AudioPath)
+	r.CoverPath = move(r.CoverPath)
+	r.OrigCoverPath = move(r.OrigCoverPath)
+	r.MetaPath = move(r.MetaPath)
+	r.LyricsPath = move(r.LyricsPath)
+	r.TransLyricsPath = move(r.TransLyricsPath)
+	r.RomaLyricsPath = move(r.RomaLyricsPath)
+	return r
+}
@@ -0,0 +1,74 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultStallTimeout is how long one read from the server may block before
+// the transfer is abandoned and retried.
+const DefaultStallTimeout = 30 * time.Second
+
+// newTransport bounds connecting and waiting for the response headers only;
+// the body may take as long as it needs while data keeps arriving.
+func newTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.ResponseHeaderTimeout = 15 * time.Second
+	return tr
+}
+
+// stallWatch cancels a transfer whose body stops delivering data. Only time
+// spent blocked in the server's Read counts, so rate limiting never trips
+// it and a long but steady download is never cut off.
+type stallWatch struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func (d *Downloader) watchStall(parent context.Context) *stallWatch {
+	timeout := d.StallTimeout
+	if timeout <= 0 {
+		timeout = DefaultStallTimeout
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	w := &stallWatch{ctx: ctx, cancel: cancel, timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() { cancel(&StallError{After: timeout}) })
+	w.timer.Stop()
+	return w
+}
+
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	return &stallReader{w: w, r: r}
+}
+
+// err reports the StallError when the watch cancelled the transfer, and err
+// unchanged otherwise.
+func (w *stallWatch) err(err error) error {
+	var se *StallError
+	if errors.As(context.Cause(w.ctx), &se) {
+		return se
+	}
+	return err
+}
+
+func (w *stallWatch) stop() {
+	w.timer.Stop()
+	w.cancel(nil)
+}
+
+type stallReader struct {
+	w *stallWatch
+	r io.Reader
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	sr.w.timer.Reset(sr.w.timeout)
+	n, err := sr.r.Read(p)
+	sr.w.timer.Stop()
+	return n, err
+}
@@ -0,0 +1,71 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type VerifyOptions struct {
+	// CheckMD5 decodes FLAC audio and compares it with the STREAMINFO MD5.
+	CheckMD5 bool
+}
+
+// VerifyAudio checks that the file at path is structurally sound audio of
+// the format named by its extension.
+func VerifyAudio(path string, opt VerifyOptions) error {
+	return verifyAudio(path, filepath.Ext(path), opt)
+}
+
+func verifyAudio(path, ext string, opt VerifyOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	if n == 0 {
+		return &IntegrityError{Path: path, Reason: "file is empty"}
+	}
+	if looksLikeMarkup(head) {
+		return &IntegrityError{Path: path, Reason: "got a web page or API error instead of audio"}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var reason string
+	switch strings.ToLower(ext) {
+	case ".flac":
+		reason, err = checkFLAC(f, opt.CheckMD5)
+	case ".mp3":
+		reason, err = checkMP3(f)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return &IntegrityError{Path: path, Reason: reason}
+	}
+	return nil
+}
+
+func looksLikeMarkup(head []byte) bool {
+	s := bytes.ToLower(bytes.TrimLeft(head, " \t\r\n\ufeff"))
+	for _, p := range []string{"<!doctype", "<html", "<?xml", "<head", "<body", "{\""} {
+		if bytes.HasPrefix(s, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	mflac "github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+)
+
+const flacTailWindow = 256 * 1024
+
+func checkFLAC(f *os.File, checkMD5 bool) (string, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	stream, err := mflac.New(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Sprintf("invalid STREAMINFO: %v", err), nil
+	}
+	info := stream.Info
+	if info == nil || info.SampleRate == 0 || info.NChannels == 0 || info.BitsPerSample == 0 {
+		return "invalid STREAMINFO", nil
+	}
+	first, err := stream.Next()
+	if err != nil {
+		return fmt.Sprintf("first audio frame: %v", err), nil
+	}
+	if first.SampleNumber() != 0 {
+		return "first audio frame does not start at sample 0", nil
+	}
+
+	if info.NSamples > 0 {
+		if reason, err := checkFLACTail(f, st.Size(), info.NSamples, int64(info.FrameSizeMax)); reason != "" || err != nil {
+			return reason, err
+		}
+	}
+	if !checkMD5 {
+		return "", nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return checkFLACMD5(f, info.NSamples, info.MD5sum)
+}
+
+// checkFLACTail looks for the frame holding the final sample near the end of
+// the file. A truncated download won't have one that decodes cleanly.
+func checkFLACTail(f *os.File, size int64, nsamples uint64, maxFrame int64) (string, error) {
+	window := int64(flacTailWindow)
+	if maxFrame > 0 && 2*maxFrame > window {
+		window = 2 * maxFrame
+	}
+	window = min(window, size)
+	tail := make([]byte, window)
+	if _, err := f.ReadAt(tail, size-window); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	for i := len(tail) - 2; i >= 0; i-- {
+		if tail[i] != 0xFF || tail[i+1]&0xFE != 0xF8 {
+			continue
+		}
+		fr, err := frame.New(bytes.NewReader(tail[i:]))
+		if err != nil {
+			continue
+		}
+		if fr.SampleNumber()+uint64(fr.BlockSize) != nsamples {
+			continue
+		}
+		if err := fr.Parse(); err != nil {
+			return "last audio frame is truncated or corrupt", nil
+		}
+		return "", nil
+	}
+	return fmt.Sprintf("no frame ends at sample %d; file looks truncated", nsamples), nil
+}
+
+func checkFLACMD5(r io.Reader, nsamples uint64, want [md5.Size]byte) (string, error) {
+	stream, err := mflac.New(bufio.NewReader(r))
+	if err != nil {
+		return fmt.Sprintf("invalid STREAMINFO: %v", err), nil
+	}
+	h := md5.New()
+	var decoded uint64
+	for {
+		fr, err := stream.ParseNext()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Sprintf("decode error after %d samples: %v", decoded, err), nil
+		}
+		fr.Hash(h)
+		decoded += uint64(fr.BlockSize)
+	}
+	if nsamples > 0 && decoded != nsamples {
+		return fmt.Sprintf("decoded %d samples, STREAMINFO says %d", decoded, nsamples), nil
+	}
+	// An all-zero MD5 means the encoder didn't compute one.
+	if want == [md5.Size]byte{} {
+		return "", nil
+	}
+	var got [md5.Size]byte
+	copy(got[:], h.Sum(nil))
+	if got != want {
+		return "audio MD5 does not match STREAMINFO", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,190 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	mp3MaxLeadingJunk = 4 * 1024
+	mp3MinFrames      = 2
+	// mp3SyncWindow leaves room past the junk for two of the largest frames
+	// (2881 bytes each).
+	mp3SyncWindow = mp3MaxLeadingJunk + 8*1024
+	// mp3TrailerWindow is how much of what follows the last frame is
+	// checked for tags or padding.
+	mp3TrailerWindow = 64 * 1024
+)
+
+var (
+	mp3BitratesV1 = [3][16]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	}
+	mp3BitratesV2 = [3][16]int{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+)
+
+type mp3Frame struct {
+	version    int
+	layer      int
+	sampleRate int
+	length     int
+}
+
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := int(b[1]>>3) & 0x3
+	layerBits := int(b[1]>>1) & 0x3
+	brIdx := int(b[2] >> 4)
+	srIdx := int(b[2]>>2) & 0x3
+	padding := int(b[2]>>1) & 0x1
+	if version == 1 || layerBits == 0 || brIdx == 0 || brIdx == 15 || srIdx == 3 {
+		return mp3Frame{}, false
+	}
+	layer := 4 - layerBits // 1, 2 or 3
+
+	var kbps int
+	if version == 3 {
+		kbps = mp3BitratesV1[layer-1][brIdx]
+	} else {
+		kbps = mp3BitratesV2[layer-1][brIdx]
+	}
+	sr := mp3SampleRates[version][srIdx]
+	bitrate := kbps * 1000
+
+	var length int
+	switch {
+	case layer == 1:
+		length = (12*bitrate/sr + padding) * 4
+	case layer == 3 && version != 3:
+		length = 72*bitrate/sr + padding
+	default:
+		length = 144*bitrate/sr + padding
+	}
+	if length < 4 {
+		return mp3Frame{}, false
+	}
+	return mp3Frame{version: version, layer: layer, sampleRate: sr, length: length}, true
+}
+
+func checkMP3(f *os.File) (string, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := st.Size()
+
+	var id3 [10]byte
+	n, err := f.ReadAt(id3[:], 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	pos := int64(id3v2Size(id3[:n]))
+	if pos >= size {
+		return "no audio after ID3 tag", nil
+	}
+
+	// Find the first frame whose successor also lines up; a lone 0xFFE is
+	// easily found in padding or embedded pictures.
+	window := make([]byte, min(size-pos, mp3SyncWindow))
+	if _, err := f.ReadAt(window, pos); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	start := -1
+	for i := range min(len(window), mp3MaxLeadingJunk) {
+		fr, ok := parseMP3Frame(window[i:])
+		if !ok {
+			continue
+		}
+		next, ok := parseMP3Frame(window[min(i+fr.length, len(window)):])
+		if ok && next.version == fr.version && next.layer == fr.layer {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return "no MPEG audio frame sync found", nil
+	}
+
+	// Walk the frame headers to the end of the audio through one buffered
+	// reader; frame bodies are discarded, not copied out.
+	first, _ := parseMP3Frame(window[start:])
+	frames := 0
+	pos += int64(start)
+	br := bufio.NewReaderSize(io.NewSectionReader(f, pos, size-pos), 64*1024)
+	for pos < size {
+		head, err := br.Peek(4)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		fr, ok := parseMP3Frame(head)
+		if !ok {
+			break
+		}
+		if fr.version != first.version || fr.layer != first.layer || fr.sampleRate != first.sampleRate {
+			return fmt.Sprintf("inconsistent frame header at offset %d", pos), nil
+		}
+		if pos+int64(fr.length) > size {
+			return fmt.Sprintf("last frame at offset %d is truncated", pos), nil
+		}
+		if _, err := br.Discard(fr.length); err != nil {
+			return "", err
+		}
+		pos += int64(fr.length)
+		frames++
+	}
+	if frames < mp3MinFrames {
+		return "too few MPEG audio frames", nil
+	}
+	rest := make([]byte, min(size-pos, mp3TrailerWindow))
+	if _, err := f.ReadAt(rest, pos); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if !isMP3Trailer(rest) {
+		return fmt.Sprintf("frame sync lost at offset %d of %d", pos, size), nil
+	}
+	return "", nil
+}
+
+func id3v2Size(data []byte) int {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0
+	}
+	size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+	size += 10
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+// isMP3Trailer accepts what may legitimately follow the last frame: tags or
+// zero padding.
+func isMP3Trailer(rest []byte) bool {
+	if len(rest) == 0 || len(bytes.Trim(rest, "\x00")) == 0 {
+		return true
+	}
+	for _, p := range []string{"TAG", "APETAGEX", "LYRICSBEGIN", "ID3"} {
+		if bytes.HasPrefix(rest, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
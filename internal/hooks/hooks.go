@@ -0,0 +1,251 @@
+package hooks
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+const (
+	EventSong  = "song"
+	EventBatch = "batch"
+
+	PolicyIgnore = "ignore"
+	PolicyWarn   = "warn"
+	PolicyFail   = "fail"
+
+	defaultTimeout = 60 * time.Second
+	maxOutputBytes = 64 * 1024
+)
+
+type Hook struct {
+	Name    string   `json:"name"`
+	Event   string   `json:"event"`
+	Command []string `json:"command"`
+	Dir     string   `json:"dir,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+	OnError string   `json:"onError,omitempty"`
+}
+
+func (h Hook) Validate() error {
+	if len(h.Command) == 0 || strings.TrimSpace(h.Command[0]) == "" {
+		return fmt.Errorf("hook %q: command required", h.Name)
+	}
+	switch h.Event {
+	case EventSong, EventBatch:
+	default:
+		return fmt.Errorf("hook %q: event must be %q or %q", h.Name, EventSong, EventBatch)
+	}
+	switch h.OnError {
+	case "", PolicyIgnore, PolicyWarn, PolicyFail:
+	default:
+		return fmt.Errorf("hook %q: onError must be ignore, warn or fail", h.Name)
+	}
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("hook %q: %w", h.Name, err)
+		}
+	}
+	return nil
+}
+
+func (h Hook) timeout() time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultTimeout
+}
+
+func (h Hook) label() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.Command[0]
+}
+
+// Runner executes hooks and plugs into download.Downloader's AfterSong and
+// AfterBatch. Each hook gets the event as KOTODAMA_* environment variables
+// and as a JSON document on stdin.
+type Runner struct {
+	Hooks []Hook
+	// Log receives every run's captured stdout/stderr; nil discards it.
+	Log io.Writer
+
+	mu sync.Mutex
+}
+
+func New(hooks []Hook, log io.Writer) (*Runner, error) {
+	for _, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Runner{Hooks: hooks, Log: log}, nil
+}
+
+type songPayload struct {
+	Event  string            `json:"event"`
+	Result download.Result   `json:"result"`
+	Item   tunehub.ParseItem `json:"item"`
+}
+
+type batchPayload struct {
+	Event   string                 `json:"event"`
+	Results []download.BatchResult `json:"results"`
+}
+
+func (r *Runner) AfterSong(ctx context.Context, res download.Result, item tunehub.ParseItem) ([]string, error) {
+	payload, err := json.Marshal(songPayload{Event: EventSong, Result: res, Item: item})
+	if err != nil {
+		return nil, err
+	}
+	env := []string{
+		"KOTODAMA_EVENT=" + EventSong,
+		"KOTODAMA_DIR=" + res.Dir,
+		"KOTODAMA_AUDIO=" + res.AudioPath,
+		"KOTODAMA_COVER=" + res.CoverPath,
+		"KOTODAMA_META=" + res.MetaPath,
+		"KOTODAMA_LYRICS=" + res.LyricsPath,
+		"KOTODAMA_TRANS_LYRICS=" + res.TransLyricsPath,
+		"KOTODAMA_ROMA_LYRICS=" + res.RomaLyricsPath,
+		"KOTODAMA_ID=" + item.ID,
+		"KOTODAMA_TITLE=" + item.Info.Name,
+		"KOTODAMA_ARTIST=" + item.Info.Artist,
+		"KOTODAMA_ALBUM=" + item.Info.Album,
+		"KOTODAMA_DURATION=" + strconv.Itoa(item.Info.Duration),
+		"KOTODAMA_QUALITY=" + cmp.Or(item.ActualQuality, item.Quality),
+	}
+	return r.fire(ctx, EventSong, env, payload)
+}
+
+func (r *Runner) AfterBatch(ctx context.Context, results []download.BatchResult) ([]string, error) {
+	payload, err := json.Marshal(batchPayload{Event: EventBatch, Results: results})
+	if err != nil {
+		return nil, err
+	}
+	failed := 0
+	for _, br := range results {
+		if br.Err != nil {
+			failed++
+		}
+	}
+	env := []string{
+		"KOTODAMA_EVENT=" + EventBatch,
+		"KOTODAMA_BATCH_SIZE=" + strconv.Itoa(len(results)),
+		"KOTODAMA_BATCH_FAILED=" + strconv.Itoa(failed),
+	}
+	return r.fire(ctx, EventBatch, env, payload)
+}
+
+func (r *Runner) fire(ctx context.Context, event string, env []string, payload []byte) ([]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	var warnings []string
+	var errs []error
+	for _, h := range r.Hooks {
+		if h.Event != event {
+			continue
+		}
+		err := r.run(ctx, h, env, payload)
+		if err == nil {
+			continue
+		}
+		switch h.OnError {
+		case PolicyIgnore:
+		case PolicyFail:
+			errs = append(errs, fmt.Errorf("hook %s: %w", h.label(), err))
+		default:
+			warnings = append(warnings, fmt.Sprintf("hook %s: %v", h.label(), err))
+		}
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// errHookTimeout tells a hook's own timeout apart from the caller's context
+// ending.
+var errHookTimeout = errors.New("hook timeout")
+
+func (r *Runner) run(ctx context.Context, h Hook, env []string, payload []byte) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, h.timeout(), errHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Dir = h.Dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(payload)
+	out := &cappedBuffer{max: maxOutputBytes}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Don't hang on grandchildren that inherited the output pipes.
+	cmd.WaitDelay = 2 * time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errHookTimeout):
+		err = fmt.Errorf("timed out after %s", h.timeout())
+	case cause != nil:
+		// The caller gave up (cancelled, or its own deadline passed); the
+		// hook itself was within its limit.
+		err = fmt.Errorf("interrupted: %w", cause)
+	}
+	r.logRun(h, start, err, out.Bytes())
+	if err != nil {
+		if tail := lastLine(out.Bytes()); tail != "" {
+			return fmt.Errorf("%w: %s", err, tail)
+		}
+	}
+	return err
+}
+
+func (r *Runner) logRun(h Hook, start time.Time, err error, output []byte) {
+	if r.Log == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	fmt.Fprintf(r.Log, "%s hook=%s event=%s took=%s status=%s\n", start.Format(time.RFC3339), h.label(), h.Event, time.Since(start).Round(time.Millisecond), status)
+	if len(output) > 0 {
+		_, _ = r.Log.Write(output)
+		if output[len(output)-1] != '\n' {
+			_, _ = io.WriteString(r.Log, "\n")
+		}
+	}
+}
+
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) Bytes() []byte { return b.buf.Bytes() }
+
+func lastLine(b []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
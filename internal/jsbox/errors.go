@@ -0,0 +1,49 @@
+package jsbox
+
+import "fmt"
+
+type ErrorKind string
+
+const (
+	// ErrTimeout: the transform ran out of time.
+	ErrTimeout ErrorKind = "timeout"
+	// ErrScript: the transform threw or returned something unusable.
+	ErrScript ErrorKind = "script"
+	// ErrSandbox: the sandbox process failed or spoke garbage.
+	ErrSandbox ErrorKind = "sandbox"
+)
+
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := "js-sandbox " + string(e.Kind)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Retryable is true for timeouts and sandbox crashes, which can be load
+// related; a throwing script throws again.
+func (e *Error) Retryable() bool {
+	return e.Kind != ErrScript
+}
+
+func (e *Error) Hint() string {
+	switch e.Kind {
+	case ErrTimeout:
+		return "the response transform took too long; the machine may be overloaded"
+	case ErrScript:
+		return "the response transform failed; the platform's response format may have changed"
+	}
+	return "the JavaScript sandbox couldn't run; check that the executable is intact"
+}
@@ -1,6 +1,13 @@
 package jsbox
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
 
 type Kind string
 
@@ -19,3 +26,53 @@ type Response struct {
 	Error  string          `json:"error,omitempty"`
 	Result json.RawMessage `json:"result,omitempty"`
 }
+
+// maxSafeInt is the largest integer a JS number holds exactly.
+const maxSafeInt = 1<<53 - 1
+
+// ScriptValue converts the json.Number values left by a UseNumber decode
+// into what a script should see. Integers a JS number can't hold exactly
+// become strings, so long platform IDs keep their digits; other numbers
+// become float64 as usual.
+func ScriptValue(v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		s := x.String()
+		if !strings.ContainsAny(s, ".eE") {
+			if n, err := strconv.ParseInt(s, 10, 64); err != nil || n > maxSafeInt || n < -maxSafeInt {
+				return s
+			}
+		}
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return s
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[k] = ScriptValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = ScriptValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// DecodeNumbers is json.Unmarshal keeping numbers as json.Number, ready for
+// ScriptValue.
+func DecodeNumbers(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package jsbox
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +26,21 @@ type Runner struct {
 	Timeout        time.Duration
 	MaxStdoutBytes int64
 	MaxStderrBytes int64
+	// Trace, if set, sees every run once it finishes.
+	Trace func(Run)
+}
+
+// Run describes a finished sandbox call for tracing.
+type Run struct {
+	Kind Kind
+	// CodeHash is the SHA-256 of the script, so runs can be matched to
+	// method configs without keeping the code.
+	CodeHash   string
+	InputBytes int
+	Duration   time.Duration
+	// Result is the script's JSON output, nil when it failed.
+	Result []byte
+	Err    error
 }
 
 func NewRunner() (*Runner, error) {
@@ -52,8 +69,28 @@ func (r *Runner) Transform(ctx context.Context, code string, response any) (any,
 }
 
 func (r *Runner) call(ctx context.Context, req Request, out any) error {
+	start := time.Now()
+	result, inputBytes, err := r.exec(ctx, req)
+	if r.Trace != nil {
+		sum := sha256.Sum256([]byte(req.Code))
+		r.Trace(Run{Kind: req.Kind, CodeHash: "sha256:" + hex.EncodeToString(sum[:]), InputBytes: inputBytes, Duration: time.Since(start), Result: result, Err: err})
+	}
+	if err != nil || out == nil {
+		return err
+	}
+	if len(result) == 0 {
+		return &Error{Kind: ErrScript, Message: "empty result"}
+	}
+	// Numbers stay json.Number so large IDs survive the decode.
+	if err := DecodeNumbers(result, out); err != nil {
+		return &Error{Kind: ErrScript, Message: "unusable result", Err: err}
+	}
+	return nil
+}
+
+func (r *Runner) exec(ctx context.Context, req Request) (json.RawMessage, int, error) {
 	if r.ExecPath == "" {
-		return errors.New("jsbox ExecPath is empty")
+		return nil, 0, errors.New("jsbox ExecPath is empty")
 	}
 	if r.Timeout <= 0 {
 		r.Timeout = defaultTimeout
@@ -70,25 +107,25 @@ func (r *Runner) call(ctx context.Context, req Request, out any) error {
 
 	input, err := json.Marshal(req)
 	if err != nil {
-		return err
+		return nil, len(input), err
 	}
 
 	cmd := exec.CommandContext(ctx, r.ExecPath, "js-sandbox")
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return err
+		return nil, len(input), err
 	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		return nil, len(input), err
 	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, len(input), err
 	}
 
 	if err := cmd.Start(); err != nil {
-		return err
+		return nil, len(input), err
 	}
 
 	go func() {
@@ -123,50 +160,51 @@ func (r *Runner) call(ctx context.Context, req Request, out any) error {
 		stderrBytes, stderrErr = readPipe(stderr, r.MaxStderrBytes)
 	}()
 
-	waitErr := cmd.Wait()
+	// Wait closes the pipes, so the readers have to finish first.
 	wg.Wait()
+	waitErr := cmd.Wait()
 
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, len(input), &Error{Kind: ErrTimeout, Message: fmt.Sprintf("no result within %s", r.Timeout)}
+	}
 	if stdoutErr != nil {
-		return stdoutErr
+		return nil, len(input), &Error{Kind: ErrSandbox, Err: stdoutErr}
 	}
 	if stderrErr != nil {
 		// stderr read errors are rare; surface them for visibility.
-		return stderrErr
+		return nil, len(input), &Error{Kind: ErrSandbox, Err: stderrErr}
 	}
 	if int64(len(stdoutBytes)) > r.MaxStdoutBytes {
-		return fmt.Errorf("js-sandbox stdout exceeded limit (%d bytes)", r.MaxStdoutBytes)
+		return nil, len(input), &Error{Kind: ErrScript, Message: fmt.Sprintf("output exceeded limit (%d bytes)", r.MaxStdoutBytes)}
 	}
 
 	if waitErr != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, len(input), err
+		}
 		errText := string(bytes.TrimSpace(stderrBytes))
 		if errText == "" {
 			errText = waitErr.Error()
 		}
-		return fmt.Errorf("js-sandbox failed: %s", errText)
+		return nil, len(input), &Error{Kind: ErrSandbox, Message: errText}
 	}
 
 	var resp Response
 	if err := json.Unmarshal(stdoutBytes, &resp); err != nil {
 		errText := string(bytes.TrimSpace(stderrBytes))
 		if errText != "" {
-			return fmt.Errorf("js-sandbox bad json: %w (stderr=%s)", err, errText)
+			return nil, len(input), &Error{Kind: ErrSandbox, Message: "bad json (stderr=" + errText + ")", Err: err}
 		}
-		return fmt.Errorf("js-sandbox bad json: %w", err)
+		return nil, len(input), &Error{Kind: ErrSandbox, Message: "bad json", Err: err}
 	}
 	if !resp.OK {
 		if resp.Error == "" {
 			resp.Error = "unknown js-sandbox error"
 		}
-		return errors.New(resp.Error)
-	}
-	if out == nil {
-		return nil
-	}
-	if len(resp.Result) == 0 {
-		return errors.New("js-sandbox returned empty result")
-	}
-	if err := json.Unmarshal(resp.Result, out); err != nil {
-		return err
+		if resp.Error == context.DeadlineExceeded.Error() {
+			return nil, len(input), &Error{Kind: ErrTimeout, Message: "script interrupted"}
+		}
+		return nil, len(input), &Error{Kind: ErrScript, Message: resp.Error}
 	}
-	return nil
+	return resp.Result, len(input), nil
 }
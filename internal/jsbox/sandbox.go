@@ -37,7 +37,7 @@ func RunSandbox() int {
 	}
 
 	var req Request
-	if err := json.Unmarshal(in, &req); err != nil {
+	if err := DecodeNumbers(in, &req); err != nil {
 		writeResp(Response{OK: false, Error: "invalid json"})
 		return 0
 	}
@@ -57,7 +57,7 @@ func RunSandbox() int {
 	ctx, cancel := context.WithTimeout(context.Background(), sandboxTimeout)
 	defer cancel()
 
-	out, err := runTransform(ctx, req.Code, req.Response)
+	out, err := runTransform(ctx, req.Code, ScriptValue(req.Response))
 	if err != nil {
 		writeResp(Response{OK: false, Error: err.Error()})
 		return 0
@@ -128,3 +128,10 @@ func runTransform(ctx context.Context, src string, response any) (out json.RawMe
 	}
 	return b, nil
 }
+
+// CheckTransform reports a syntax error in a transform without running it,
+// so it's safe outside the sandbox.
+func CheckTransform(src string) error {
+	_, err := goja.Compile("transform", "("+src+")", false)
+	return err
+}
@@ -0,0 +1,131 @@
+package lyrics
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	timeTagRe = regexp.MustCompile(`^\[(\d{1,3}):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	metaTagRe = regexp.MustCompile(`^\[[A-Za-z#]+:[^\]]*\]$`)
+)
+
+type Line struct {
+	// Millis is the timestamp in milliseconds.
+	Millis int64
+	Text   string
+}
+
+type Doc struct {
+	Tags  []string
+	Lines []Line
+}
+
+func Parse(s string) Doc {
+	var doc Doc
+	for _, raw := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		var stamps []int64
+		rest := raw
+		for {
+			m := timeTagRe.FindStringSubmatch(rest)
+			if m == nil {
+				break
+			}
+			stamps = append(stamps, stampMillis(m[1], m[2], m[3]))
+			rest = rest[len(m[0]):]
+		}
+		if len(stamps) == 0 {
+			if metaTagRe.MatchString(raw) {
+				doc.Tags = append(doc.Tags, raw)
+			}
+			continue
+		}
+		text := strings.TrimSpace(rest)
+		for _, ms := range stamps {
+			doc.Lines = append(doc.Lines, Line{Millis: ms, Text: text})
+		}
+	}
+	sort.SliceStable(doc.Lines, func(i, j int) bool { return doc.Lines[i].Millis < doc.Lines[j].Millis })
+	return doc
+}
+
+func (d Doc) String() string {
+	var b strings.Builder
+	for _, t := range d.Tags {
+		b.WriteString(t)
+		b.WriteByte('\n')
+	}
+	for _, l := range d.Lines {
+		b.WriteString(FormatStamp(l.Millis))
+		b.WriteString(l.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Merge interleaves trans under orig: each original line is followed by the
+// translated line carrying the same timestamp. Untimed input is returned
+// unchanged.
+func Merge(orig, trans string) string {
+	if strings.TrimSpace(trans) == "" {
+		return orig
+	}
+	o := Parse(orig)
+	t := Parse(trans)
+	if len(o.Lines) == 0 || len(t.Lines) == 0 {
+		return orig
+	}
+
+	// Keyed at centisecond precision; sources disagree on 2 vs 3 digit fractions.
+	byStamp := make(map[int64]string, len(t.Lines))
+	for _, l := range t.Lines {
+		if l.Text == "" {
+			continue
+		}
+		if _, ok := byStamp[l.Millis/10]; !ok {
+			byStamp[l.Millis/10] = l.Text
+		}
+	}
+
+	out := Doc{Tags: o.Tags, Lines: make([]Line, 0, len(o.Lines)*2)}
+	for _, l := range o.Lines {
+		out.Lines = append(out.Lines, l)
+		if l.Text == "" {
+			continue
+		}
+		if tr, ok := byStamp[l.Millis/10]; ok && tr != l.Text {
+			out.Lines = append(out.Lines, Line{Millis: l.Millis, Text: tr})
+		}
+	}
+	return out.String()
+}
+
+func FormatStamp(ms int64) string {
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("[%02d:%02d.%02d]", ms/60000, ms/1000%60, ms%1000/10)
+}
+
+func stampMillis(min, sec, frac string) int64 {
+	m, _ := strconv.ParseInt(min, 10, 64)
+	s, _ := strconv.ParseInt(sec, 10, 64)
+	var f int64
+	if frac != "" {
+		f, _ = strconv.ParseInt(frac, 10, 64)
+		switch len(frac) {
+		case 1:
+			f *= 100
+		case 2:
+			f *= 10
+		}
+	}
+	return m*60000 + s*1000 + f
+}
@@ -0,0 +1,316 @@
+// Package netroute picks per-host HTTP settings: IP family, proxy, HTTP
+// version, host rewrites, extra headers and timeouts.
+package netroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule applies to requests whose host matches Host and, if set, whose path
+// starts with PathPrefix. The first matching rule wins.
+type Rule struct {
+	// Host is an exact hostname or "*.example.com" for any subdomain.
+	Host       string `json:"host"`
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// IPFamily is "4", "6" or empty for either.
+	IPFamily string `json:"ipFamily,omitempty"`
+	// Proxy is a proxy URL, "direct" for none, or empty to use the
+	// environment (HTTPS_PROXY and friends).
+	Proxy string `json:"proxy,omitempty"`
+	// HTTP1 turns HTTP/2 off for the host.
+	HTTP1 bool `json:"http1,omitempty"`
+	// RewriteHost sends the request to this host instead, keeping the port.
+	RewriteHost string `json:"rewriteHost,omitempty"`
+	// Headers are set on every matching request, replacing existing values.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout bounds the whole request, e.g. "10s".
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Validate reports the first field that can't be used.
+func (r Rule) Validate() error {
+	if strings.TrimSpace(r.Host) == "" {
+		return errors.New("route: host required")
+	}
+	switch r.IPFamily {
+	case "", "4", "6":
+	default:
+		return fmt.Errorf("route %s: ipFamily must be 4 or 6, not %q", r.Host, r.IPFamily)
+	}
+	if _, err := r.proxyURL(); err != nil {
+		return fmt.Errorf("route %s: %w", r.Host, err)
+	}
+	if _, err := r.timeout(); err != nil {
+		return fmt.Errorf("route %s: timeout: %w", r.Host, err)
+	}
+	return nil
+}
+
+func (r Rule) matches(host, path string) bool {
+	pat := strings.ToLower(strings.TrimSpace(r.Host))
+	if suffix, ok := strings.CutPrefix(pat, "*."); ok {
+		if !strings.HasSuffix(host, "."+suffix) {
+			return false
+		}
+	} else if host != pat {
+		return false
+	}
+	return r.PathPrefix == "" || strings.HasPrefix(path, r.PathPrefix)
+}
+
+// proxyURL returns nil for "direct" and for the environment default.
+func (r Rule) proxyURL() (*url.URL, error) {
+	p := strings.TrimSpace(r.Proxy)
+	if p == "" || p == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy: unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy: missing host in %q", p)
+	}
+	return u, nil
+}
+
+// ValidateProxy checks a proxy setting as Rule.Proxy accepts it.
+func ValidateProxy(proxy string) error {
+	_, err := (Rule{Proxy: proxy}).proxyURL()
+	return err
+}
+
+func (r Rule) timeout() (time.Duration, error) {
+	if strings.TrimSpace(r.Timeout) == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(strings.TrimSpace(r.Timeout))
+}
+
+// ProviderRules are the workarounds each platform needs out of the box.
+// Config rules are checked first, so they can override these.
+var ProviderRules = map[string][]Rule{
+	"netease": {
+		// The web API answers on interface.music.163.com without the web
+		// front end's checks; both are flaky over IPv6 and HTTP/2.
+		{Host: "music.163.com", PathPrefix: "/api/", RewriteHost: "interface.music.163.com", IPFamily: "4", HTTP1: true},
+		{Host: "music.163.com", IPFamily: "4", HTTP1: true},
+		{Host: "interface.music.163.com", IPFamily: "4", HTTP1: true},
+		{Host: "interface3.music.163.com", IPFamily: "4", HTTP1: true},
+	},
+}
+
+// Defaults returns every provider rule.
+func Defaults() []Rule {
+	var rules []Rule
+	for _, p := range []string{"netease"} {
+		rules = append(rules, ProviderRules[p]...)
+	}
+	return rules
+}
+
+// Router hands out an http.Client per distinct transport setting, so rules
+// that only differ in headers or rewrites share connections.
+type Router struct {
+	rules   []Rule
+	timeout time.Duration
+	// platforms maps a platform to the proxy its API calls use when no rule
+	// names one.
+	platforms map[string]string
+	// Wrap, if set, wraps every transport the router builds, e.g. to trace
+	// requests. Set it before the first request.
+	Wrap func(http.RoundTripper) http.RoundTripper
+
+	mu      sync.Mutex
+	clients map[clientKey]*http.Client
+}
+
+// Decision is how a request is sent.
+type Decision struct {
+	// Rule is the matched rule's host pattern, empty when none matched.
+	Rule string
+	// Host is where the request goes after rewrites.
+	Host     string
+	IPFamily string
+	HTTP1    bool
+	// Proxy is a proxy URL, "direct", or empty for the environment.
+	Proxy string
+	// ProxyFrom says where Proxy came from: "rule", "platform <name>" or
+	// "environment".
+	ProxyFrom string
+	Headers   map[string]string
+	Timeout   time.Duration
+}
+
+type platformKey struct{}
+
+// WithPlatform marks requests made with ctx as API calls for platform, so
+// its proxy from SetPlatformProxy applies.
+func WithPlatform(ctx context.Context, platform string) context.Context {
+	return context.WithValue(ctx, platformKey{}, strings.ToLower(strings.TrimSpace(platform)))
+}
+
+func platformOf(ctx context.Context) string {
+	p, _ := ctx.Value(platformKey{}).(string)
+	return p
+}
+
+type clientKey struct {
+	family  string
+	proxy   string
+	http1   bool
+	timeout time.Duration
+}
+
+// New validates rules. timeout applies to requests no rule gives one.
+func New(rules []Rule, timeout time.Duration) (*Router, error) {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Router{rules: rules, timeout: timeout, platforms: map[string]string{}, clients: map[clientKey]*http.Client{}}, nil
+}
+
+// SetPlatformProxy sends platform's API calls through proxy (a URL or
+// "direct") unless a rule for the host says otherwise. TuneHub itself and
+// download CDNs aren't platform calls and keep their own routes.
+func (rt *Router) SetPlatformProxy(platform, proxy string) error {
+	platform = strings.ToLower(strings.TrimSpace(platform))
+	if err := ValidateProxy(proxy); err != nil {
+		return fmt.Errorf("platform %s: %w", platform, err)
+	}
+	rt.mu.Lock()
+	rt.platforms[platform] = strings.TrimSpace(proxy)
+	rt.mu.Unlock()
+	return nil
+}
+
+// Default routes with Defaults only.
+func Default(timeout time.Duration) *Router {
+	r, err := New(Defaults(), timeout)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Match returns the rule for host and path, if any.
+func (rt *Router) Match(host, path string) (Rule, bool) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	for _, r := range rt.rules {
+		if r.matches(host, path) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Decide works out how a request for u, made for platform (may be empty),
+// would be sent.
+func (rt *Router) Decide(u *url.URL, platform string) Decision {
+	r, ok := rt.Match(u.Hostname(), u.Path)
+	d := Decision{Host: u.Host, IPFamily: r.IPFamily, HTTP1: r.HTTP1, Headers: r.Headers, Timeout: rt.timeout}
+	if ok {
+		d.Rule = r.Host
+		if r.PathPrefix != "" {
+			d.Rule += " " + r.PathPrefix
+		}
+	}
+	if h := strings.TrimSpace(r.RewriteHost); h != "" {
+		if port := u.Port(); port != "" {
+			h = net.JoinHostPort(h, port)
+		}
+		d.Host = h
+	}
+	if t, _ := r.timeout(); t > 0 {
+		d.Timeout = t
+	}
+	d.Proxy, d.ProxyFrom = strings.TrimSpace(r.Proxy), "rule"
+	if d.Proxy == "" {
+		rt.mu.Lock()
+		p, ok := rt.platforms[strings.ToLower(platform)]
+		rt.mu.Unlock()
+		if ok && p != "" {
+			d.Proxy, d.ProxyFrom = p, "platform "+strings.ToLower(platform)
+		} else {
+			d.ProxyFrom = "environment"
+		}
+	}
+	return d
+}
+
+// Route applies the decision for req: the host rewrite and headers go on
+// req, and the returned client carries the rest.
+func (rt *Router) Route(req *http.Request) *http.Client {
+	d := rt.Decide(req.URL, platformOf(req.Context()))
+	req.URL.Host = d.Host
+	req.Host = d.Host
+	for k, v := range d.Headers {
+		req.Header.Set(k, v)
+	}
+	return rt.client(d)
+}
+
+func (rt *Router) client(d Decision) *http.Client {
+	key := clientKey{family: d.IPFamily, proxy: d.Proxy, http1: d.HTTP1, timeout: d.Timeout}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if c, ok := rt.clients[key]; ok {
+		return c
+	}
+	var tr http.RoundTripper = newTransport(d)
+	if rt.Wrap != nil {
+		tr = rt.Wrap(tr)
+	}
+	c := &http.Client{Timeout: d.Timeout, Transport: tr}
+	rt.clients[key] = c
+	return c
+}
+
+// newTransport leaves HTTP CONNECT and SOCKS5 (including user:pass auth
+// from the proxy URL) to net/http.
+func newTransport(d Decision) *http.Transport {
+	network := ""
+	switch d.IPFamily {
+	case "4":
+		network = "tcp4"
+	case "6":
+		network = "tcp6"
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, nw, addr string) (net.Conn, error) {
+			if network != "" {
+				nw = network
+			}
+			return dialer.DialContext(ctx, nw, addr)
+		},
+		ForceAttemptHTTP2:     !d.HTTP1,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		IdleConnTimeout:       30 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	switch u, _ := (Rule{Proxy: d.Proxy}).proxyURL(); {
+	case u != nil:
+		tr.Proxy = http.ProxyURL(u)
+	case d.Proxy == "direct":
+		tr.Proxy = nil
+	}
+	return tr
+}
@@ -0,0 +1,179 @@
+package playlist
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+)
+
+type Entry struct {
+	Path   string
+	Title  string
+	Artist string
+	Album  string
+	// Seconds is the track length; <= 0 when unknown.
+	Seconds int
+}
+
+func EntryFor(res download.Result, item tunehub.ParseItem) Entry {
+	return Entry{
+		Path:    res.AudioPath,
+		Title:   item.Info.Name,
+		Artist:  item.Info.Artist,
+		Album:   item.Info.Album,
+		Seconds: item.Info.Duration,
+	}
+}
+
+// FromBatch keeps the batch's original order and skips failed items.
+func FromBatch(results []download.BatchResult) []Entry {
+	out := make([]Entry, 0, len(results))
+	for _, br := range results {
+		if br.Err != nil || br.Result.AudioPath == "" {
+			continue
+		}
+		out = append(out, EntryFor(br.Result, br.Item))
+	}
+	return out
+}
+
+func FormatFromExt(p string) (string, error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".m3u8", ".m3u":
+		return FormatM3U8, nil
+	case ".xspf":
+		return FormatXSPF, nil
+	default:
+		return "", fmt.Errorf("unknown playlist format: %q", filepath.Ext(p))
+	}
+}
+
+func Ext(format string) string {
+	if format == FormatXSPF {
+		return ".xspf"
+	}
+	return ".m3u8"
+}
+
+// WriteFile writes entries to p with paths relative to p's directory,
+// replacing any existing file atomically.
+func WriteFile(p, format string, entries []Entry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".playlist-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	base := filepath.Dir(p)
+	switch format {
+	case FormatM3U8:
+		err = WriteM3U8(w, entries, base)
+	case FormatXSPF:
+		err = WriteXSPF(w, entries, base)
+	default:
+		err = fmt.Errorf("unknown playlist format: %q", format)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func WriteM3U8(w io.Writer, entries []Entry, base string) error {
+	if _, err := io.WriteString(w, "#EXTM3U\n"); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		secs := e.Seconds
+		if secs <= 0 {
+			secs = -1
+		}
+		label := e.Title
+		if e.Artist != "" {
+			label = e.Artist + " - " + e.Title
+		}
+		if _, err := fmt.Fprintf(w, "#EXTINF:%d,%s\n%s\n", secs, oneLine(label), relPath(base, e.Path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"playlist"`
+	Version   string      `xml:"version,attr"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	TrackList []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"`
+}
+
+func WriteXSPF(w io.Writer, entries []Entry, base string) error {
+	pl := xspfPlaylist{Version: "1", Xmlns: "http://xspf.org/ns/0/"}
+	for _, e := range entries {
+		loc := (&url.URL{Path: relPath(base, e.Path)}).String()
+		pl.TrackList = append(pl.TrackList, xspfTrack{
+			Location: loc,
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: int64(max(e.Seconds, 0)) * 1000,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(pl); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func relPath(base, p string) string {
+	if base != "" {
+		ab, err1 := filepath.Abs(base)
+		ap, err2 := filepath.Abs(p)
+		if err1 == nil && err2 == nil {
+			if rel, err := filepath.Rel(ab, ap); err == nil {
+				p = rel
+			}
+		}
+	}
+	return filepath.ToSlash(p)
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,144 @@
+package plsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	StateFileName = ".kotodama-sync.json"
+	TrashDirName  = ".trash"
+	lockFileName  = ".kotodama-sync.lock"
+
+	// A running sync touches its lock every lockRefresh; one left alone
+	// for staleLockAge belongs to a run that was killed.
+	lockRefresh  = time.Minute
+	staleLockAge = 10 * time.Minute
+)
+
+// State is the record of what a sync dir holds. Paths are relative to the
+// dir so the folder can be moved.
+type State struct {
+	Platform     string    `json:"platform"`
+	Kind         string    `json:"kind"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PlaylistFile string    `json:"playlistFile,omitempty"`
+	SyncedAt     time.Time `json:"syncedAt"`
+	Tracks       []Track   `json:"tracks"`
+}
+
+type Track struct {
+	ID      string `json:"id"`
+	Dir     string `json:"dir"`
+	Audio   string `json:"audio"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Album   string `json:"album,omitempty"`
+	Seconds int    `json:"seconds,omitempty"`
+	// Removed tracks are gone from the remote list but still on disk
+	// because the run wasn't asked to prune them.
+	Removed bool      `json:"removed,omitempty"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+func LoadState(dir string) (State, error) {
+	var st State
+	b, err := os.ReadFile(filepath.Join(dir, StateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", StateFileName, err)
+	}
+	return st, nil
+}
+
+func (st State) Save(dir string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, StateFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, StateFileName))
+}
+
+func (st State) track(id string) (int, bool) {
+	for i, t := range st.Tracks {
+		if t.ID == id {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// keepLock refreshes the lock at p until the returned func removes it, so
+// a long sync never looks stale to the next run.
+func keepLock(p string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(lockRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-t.C:
+				_ = os.Chtimes(p, now, now)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		_ = os.Remove(p)
+	}
+}
+
+// lock takes the dir's sync lock so overlapping cron runs don't fight over
+// the state file. The returned func releases it.
+func lock(dir string) (func(), error) {
+	p := filepath.Join(dir, lockFileName)
+	for range 2 {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, _ = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			_ = f.Close()
+			return keepLock(p), nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		fi, serr := os.Stat(p)
+		if serr != nil || time.Since(fi.ModTime()) < staleLockAge {
+			break
+		}
+		_ = os.Remove(p)
+	}
+	return nil, fmt.Errorf("another sync is running in %s (remove %s if not)", dir, lockFileName)
+}
@@ -0,0 +1,322 @@
+// Package plsync keeps a local folder in step with a remote playlist.
+package plsync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/playlist"
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+type Syncer struct {
+	TH      *tunehub.Client
+	DL      *download.Downloader
+	APIKey  string
+	Quality string
+	// Prune moves tracks that left the remote list into TrashDirName instead
+	// of leaving them on disk.
+	Prune bool
+	// PlaylistFormat defaults to m3u8.
+	PlaylistFormat string
+	// PlaylistName is the playlist file name without extension; defaults to
+	// the name recorded in the state, then the collection name.
+	PlaylistName string
+	// Log receives one line per track event; nil discards them.
+	Log io.Writer
+}
+
+type Report struct {
+	Name     string
+	Total    int
+	Added    int
+	Removed  int
+	Trashed  int
+	Failed   []error
+	Playlist string
+}
+
+// Run syncs dir with the collection link points at. It is safe to run
+// repeatedly: tracks already on disk are skipped and a lock keeps
+// overlapping runs apart.
+func (s *Syncer) Run(ctx context.Context, link tunehub.Link, dir string) (Report, error) {
+	var rep Report
+	if link.Kind == tunehub.KindSong {
+		return rep, errors.New("sync needs a playlist, album or artist, not a song")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return rep, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return rep, err
+	}
+	unlock, err := lock(dir)
+	if err != nil {
+		return rep, err
+	}
+	defer unlock()
+
+	st, err := LoadState(dir)
+	if err != nil {
+		return rep, err
+	}
+	if st.ID != "" && (st.Platform != link.Platform || st.Kind != link.Kind || st.ID != link.ID) {
+		return rep, fmt.Errorf("%s is synced with %s %s %s", dir, st.Platform, st.Kind, st.ID)
+	}
+
+	coll, err := s.TH.OpenCollection(ctx, link.Platform, link.Kind, link.ID)
+	if err != nil {
+		return rep, err
+	}
+	// An empty answer is far more likely an upstream hiccup than a playlist
+	// that was emptied; don't trash the whole folder over it.
+	if len(coll.Tracks) == 0 && len(st.Tracks) > 0 {
+		return rep, errors.New("remote list came back empty; refusing to sync")
+	}
+	st.Platform, st.Kind, st.ID = link.Platform, link.Kind, link.ID
+	if coll.Name != "" {
+		st.Name = coll.Name
+	}
+	rep.Name = st.Name
+
+	var order []string
+	remote := map[string]tunehub.SearchItem{}
+	for _, t := range coll.Tracks {
+		if _, dup := remote[t.ID]; dup || t.ID == "" {
+			continue
+		}
+		remote[t.ID] = t
+		order = append(order, t.ID)
+	}
+	rep.Total = len(order)
+
+	var missing []string
+	for _, id := range order {
+		i, ok := st.track(id)
+		if ok && st.Tracks[i].Audio != "" && fileExists(filepath.Join(dir, st.Tracks[i].Audio)) {
+			st.Tracks[i].Removed = false
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	items := s.parse(ctx, link.Platform, missing, &rep)
+	if len(items) > 0 {
+		results, warnings, err := s.DL.DownloadBatch(ctx, dir, items, func(ev download.BatchEvent) {
+			if !ev.Done {
+				return
+			}
+			if ev.Result.Err != nil {
+				s.logf("FAIL %s: %v", ev.Result.Item.ID, ev.Result.Err)
+			} else {
+				s.logf("add  %s", ev.Result.Result.Dir)
+			}
+		})
+		for _, w := range warnings {
+			s.logf("warning: %s", w)
+		}
+		for _, br := range results {
+			if br.Err != nil {
+				rep.Failed = append(rep.Failed, fmt.Errorf("%s: %w", br.Item.ID, br.Err))
+				continue
+			}
+			st.upsert(dir, br)
+			rep.Added++
+		}
+		if err != nil {
+			rep.Failed = append(rep.Failed, err)
+		}
+	}
+
+	// An interrupted run never prunes: whatever it decided was gone, it
+	// had no chance to finish bringing the rest in line.
+	prune := s.Prune
+	if prune && ctx.Err() != nil {
+		s.logf("interrupted; not pruning")
+		prune = false
+	}
+	stamp := time.Now().Format("20060102-150405")
+	kept := st.Tracks[:0]
+	for _, t := range st.Tracks {
+		if _, ok := remote[t.ID]; ok {
+			kept = append(kept, t)
+			continue
+		}
+		if !prune {
+			if !t.Removed {
+				s.logf("gone %s (kept on disk)", t.Dir)
+				rep.Removed++
+			}
+			t.Removed = true
+			kept = append(kept, t)
+			continue
+		}
+		if err := trash(dir, stamp, t.Dir); err != nil {
+			rep.Failed = append(rep.Failed, fmt.Errorf("trash %s: %w", t.Dir, err))
+			t.Removed = true
+			kept = append(kept, t)
+			continue
+		}
+		s.logf("trash %s", t.Dir)
+		rep.Trashed++
+	}
+	st.Tracks = kept
+	st.sortBy(order)
+	st.SyncedAt = time.Now().UTC()
+
+	if p, err := s.writePlaylist(dir, &st); err != nil {
+		rep.Failed = append(rep.Failed, fmt.Errorf("playlist: %w", err))
+	} else {
+		rep.Playlist = p
+	}
+	if err := st.Save(dir); err != nil {
+		return rep, err
+	}
+	return rep, ctx.Err()
+}
+
+func (s *Syncer) parse(ctx context.Context, platform string, ids []string, rep *Report) []tunehub.ParseItem {
+	var items []tunehub.ParseItem
+	s.TH.ParseChunks(ctx, s.APIKey, platform, ids, s.Quality, func(chunk []string, pd tunehub.ParseData, err error) {
+		if err != nil {
+			for _, id := range chunk {
+				rep.Failed = append(rep.Failed, fmt.Errorf("%s: parse: %w", id, err))
+			}
+			s.logf("FAIL parse: %v", err)
+			return
+		}
+		for _, pi := range pd.Data {
+			if !pi.Success {
+				errText := strings.TrimSpace(pi.Error)
+				if errText == "" {
+					errText = "parse failed"
+				}
+				rep.Failed = append(rep.Failed, fmt.Errorf("%s: %s", pi.ID, errText))
+				s.logf("FAIL %s: %s", pi.ID, errText)
+				continue
+			}
+			if err := s.TH.AttachLyrics(ctx, platform, &pi); err != nil {
+				s.logf("warning: %s: lyrics: %v", pi.ID, err)
+			}
+			items = append(items, pi)
+		}
+	})
+	return items
+}
+
+func (s *Syncer) writePlaylist(dir string, st *State) (string, error) {
+	format := s.PlaylistFormat
+	if format == "" {
+		format = playlist.FormatM3U8
+	}
+	name := s.PlaylistName
+	if name == "" && st.PlaylistFile != "" {
+		name = strings.TrimSuffix(st.PlaylistFile, filepath.Ext(st.PlaylistFile))
+	}
+	if name == "" {
+		name = download.SanitizeName(cmp.Or(st.Name, st.Kind+"-"+st.ID))
+	}
+	file := name + playlist.Ext(format)
+
+	var entries []playlist.Entry
+	for _, t := range st.Tracks {
+		if t.Removed {
+			continue
+		}
+		entries = append(entries, playlist.Entry{
+			Path:    filepath.Join(dir, t.Audio),
+			Title:   t.Title,
+			Artist:  t.Artist,
+			Album:   t.Album,
+			Seconds: t.Seconds,
+		})
+	}
+	p := filepath.Join(dir, file)
+	if err := playlist.WriteFile(p, format, entries); err != nil {
+		return "", err
+	}
+	st.PlaylistFile = file
+	return p, nil
+}
+
+func (st *State) upsert(dir string, br download.BatchResult) {
+	rel := func(p string) string {
+		if r, err := filepath.Rel(dir, p); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return p
+	}
+	e := playlist.EntryFor(br.Result, br.Item)
+	t := Track{
+		ID:      br.Item.ID,
+		Dir:     rel(br.Result.Dir),
+		Audio:   rel(br.Result.AudioPath),
+		Title:   e.Title,
+		Artist:  e.Artist,
+		Album:   e.Album,
+		Seconds: e.Seconds,
+		AddedAt: time.Now().UTC(),
+	}
+	if i, ok := st.track(t.ID); ok {
+		st.Tracks[i] = t
+		return
+	}
+	st.Tracks = append(st.Tracks, t)
+}
+
+// sortBy puts tracks in remote order; removed ones trail in their old order.
+func (st *State) sortBy(order []string) {
+	pos := make(map[string]int, len(order))
+	for i, id := range order {
+		pos[id] = i
+	}
+	sorted := make([]Track, 0, len(st.Tracks))
+	var rest []Track
+	byID := map[string]Track{}
+	for _, t := range st.Tracks {
+		if _, ok := pos[t.ID]; ok {
+			byID[t.ID] = t
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	for _, id := range order {
+		if t, ok := byID[id]; ok {
+			sorted = append(sorted, t)
+		}
+	}
+	st.Tracks = append(sorted, rest...)
+}
+
+func trash(dir, stamp, trackDir string) error {
+	src := filepath.Join(dir, trackDir)
+	if !fileExists(src) {
+		return nil
+	}
+	dst := filepath.Join(dir, TrashDirName, stamp, filepath.Base(trackDir))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+func (s *Syncer) logf(format string, args ...any) {
+	if s.Log != nil {
+		fmt.Fprintf(s.Log, format+"\n", args...)
+	}
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return !errors.Is(err, fs.ErrNotExist)
+}
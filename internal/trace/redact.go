@@ -0,0 +1,119 @@
+package trace
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var secretHeaders = []string{"X-Api-Key", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// urlHeaders hold a URL, possibly relative, that may carry a signature:
+// a redirect to a signed CDN link is the usual case.
+var urlHeaders = []string{"Location", "Content-Location", "Referer"}
+
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, k := range secretHeaders {
+		if _, ok := out[k]; ok {
+			out[k] = []string{redacted}
+		}
+	}
+	for _, k := range urlHeaders {
+		for i, v := range out[k] {
+			if u, err := url.Parse(v); err == nil {
+				out[k][i] = RedactURL(u)
+			} else {
+				out[k][i] = redacted
+			}
+		}
+	}
+	for k, vs := range out {
+		if secretName(k) {
+			out[k] = []string{redacted}
+			continue
+		}
+		// Link, Refresh and the like embed absolute URLs in other text.
+		for i, v := range vs {
+			vs[i] = redactURLs(v)
+		}
+	}
+	return out
+}
+
+// secretName reports whether a query parameter, form field, JSON key or
+// header likely holds a credential or a URL signature. "keyword" and the
+// like are kept: they're what a bug report needs.
+func secretName(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range []string{"token", "sign", "secret", "passw", "credential", "cookie", "session"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	return n == "sig" || n == "auth" || strings.HasSuffix(n, "key") || strings.HasSuffix(n, "_auth")
+}
+
+// RedactURL drops user info and blanks secret query parameters.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	c := *u
+	if c.User != nil {
+		c.User = url.User(redacted)
+	}
+	if c.RawQuery != "" {
+		q := c.Query()
+		for k := range q {
+			if secretName(k) {
+				q[k] = []string{redacted}
+			}
+		}
+		c.RawQuery = q.Encode()
+	}
+	return c.String()
+}
+
+var (
+	urlRe      = regexp.MustCompile(`https?://[^\s"'<>\\]+`)
+	jsonPairRe = regexp.MustCompile(`"([^"\\]{1,64})"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// redactText scrubs a body: URLs anywhere in it, secret-looking JSON
+// string fields, and secret fields of a form body.
+func redactText(s, contentType string) string {
+	if mt, _, _ := mime.ParseMediaType(contentType); mt == "application/x-www-form-urlencoded" {
+		if q, err := url.ParseQuery(s); err == nil {
+			for k := range q {
+				if secretName(k) {
+					q[k] = []string{redacted}
+				}
+			}
+			s = q.Encode()
+		}
+	}
+	s = redactURLs(s)
+	return jsonPairRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := jsonPairRe.FindStringSubmatch(m)
+		if !secretName(sub[1]) {
+			return m
+		}
+		return `"` + sub[1] + `"` + sub[2] + `"` + redacted + `"`
+	})
+}
+
+// redactURLs runs every absolute URL in s through RedactURL.
+func redactURLs(s string) string {
+	return urlRe.ReplaceAllStringFunc(s, func(m string) string {
+		u, err := url.Parse(m)
+		if err != nil {
+			return m
+		}
+		return RedactURL(u)
+	})
+}
@@ -0,0 +1,231 @@
+// Package trace records HTTP exchanges and jsbox runs as JSON lines for bug
+// reports. API keys, cookies, credentials and URL signatures are redacted
+// before anything is written.
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kotodama-kamataichi/internal/jsbox"
+)
+
+// EnvVar names the trace file when the -trace flag isn't given.
+const EnvVar = "KOTODAMA_TRACE"
+
+// DefaultBodyLimit is how much of each body, and of each jsbox result, is
+// kept.
+const DefaultBodyLimit = 4096
+
+// Record is one line of the trace.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Kind is "http" or "jsbox".
+	Kind string `json:"kind"`
+	// Source is the component that made the request: "tunehub" or
+	// "download".
+	Source          string      `json:"source,omitempty"`
+	Method          string      `json:"method,omitempty"`
+	URL             string      `json:"url,omitempty"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	RequestBody     string      `json:"requestBody,omitempty"`
+	Status          int         `json:"status,omitempty"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody    string      `json:"responseBody,omitempty"`
+	ResponseBytes   int64       `json:"responseBytes,omitempty"`
+	// Truncated is set when a body or result was cut at the limit.
+	Truncated bool `json:"truncated,omitempty"`
+	// HeadersMS is the time to the response headers; DurationMS runs until
+	// the body was closed.
+	HeadersMS float64 `json:"headersMs,omitempty"`
+
+	// Function, CodeHash and InputBytes describe a jsbox run; its output is
+	// in Result.
+	Function   string `json:"function,omitempty"`
+	CodeHash   string `json:"codeHash,omitempty"`
+	InputBytes int    `json:"inputBytes,omitempty"`
+	Result     string `json:"result,omitempty"`
+
+	DurationMS float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Tracer appends records to a file. It is safe for concurrent use.
+type Tracer struct {
+	// BodyLimit caps each kept body; 0 means DefaultBodyLimit.
+	BodyLimit int
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Open appends to the trace file at path. The file is readable by the
+// owner only: even redacted, it shows what was searched and downloaded.
+func Open(path string) (*Tracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{w: f}, nil
+}
+
+// Close closes the trace file; records that arrive later are dropped.
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.w.(io.Closer)
+	t.w = io.Discard
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+func (t *Tracer) write(rec Record) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Tracing never fails the traced call.
+	_, _ = t.w.Write(append(b, '\n'))
+}
+
+func (t *Tracer) limit() int {
+	if t.BodyLimit > 0 {
+		return t.BodyLimit
+	}
+	return DefaultBodyLimit
+}
+
+// Wrap traces every exchange sent through base (nil means
+// http.DefaultTransport), tagging records with source.
+func (t *Tracer) Wrap(source string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{t: t, source: source, base: base}
+}
+
+// JSBox records a jsbox run; set it as jsbox.Runner.Trace.
+func (t *Tracer) JSBox(run jsbox.Run) {
+	rec := Record{
+		Time:       time.Now().Add(-run.Duration),
+		Kind:       "jsbox",
+		Function:   string(run.Kind),
+		CodeHash:   run.CodeHash,
+		InputBytes: run.InputBytes,
+		DurationMS: ms(run.Duration),
+	}
+	rec.Result, rec.Truncated = t.clip(run.Result)
+	rec.Result = redactText(rec.Result, "application/json")
+	if run.Err != nil {
+		rec.Error = redactText(run.Err.Error(), "")
+	}
+	t.write(rec)
+}
+
+func (t *Tracer) clip(b []byte) (string, bool) {
+	if len(b) > t.limit() {
+		return string(b[:t.limit()]), true
+	}
+	return string(b), false
+}
+
+type transport struct {
+	t      *Tracer
+	source string
+	base   http.RoundTripper
+}
+
+func (tr *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	rec := Record{
+		Time:           start,
+		Kind:           "http",
+		Source:         tr.source,
+		Method:         req.Method,
+		URL:            RedactURL(req.URL),
+		RequestHeaders: redactHeader(req.Header),
+	}
+	// Only bodies that can be replayed are read; the request still gets
+	// its own copy.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := io.ReadAll(io.LimitReader(body, int64(tr.t.limit())+1))
+			_ = body.Close()
+			rec.RequestBody, rec.Truncated = tr.t.clip(b)
+			rec.RequestBody = redactText(rec.RequestBody, req.Header.Get("Content-Type"))
+		}
+	}
+
+	res, err := tr.base.RoundTrip(req)
+	rec.HeadersMS = ms(time.Since(start))
+	if err != nil {
+		rec.DurationMS = rec.HeadersMS
+		rec.Error = redactText(err.Error(), "")
+		tr.t.write(rec)
+		return nil, err
+	}
+	rec.Status = res.StatusCode
+	rec.ResponseHeaders = redactHeader(res.Header)
+	ctype := res.Header.Get("Content-Type")
+	res.Body = &tracedBody{ReadCloser: res.Body, t: tr.t, rec: rec, start: start, keep: isText(ctype), ctype: ctype}
+	return res, nil
+}
+
+// tracedBody writes the record once the caller is done with the body, so
+// the timing covers the whole transfer.
+type tracedBody struct {
+	io.ReadCloser
+	t     *Tracer
+	rec   Record
+	start time.Time
+	keep  bool
+	ctype string
+	buf   bytes.Buffer
+	n     int64
+	once  sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.keep && b.buf.Len() <= b.t.limit() {
+		b.buf.Write(p[:min(n, b.t.limit()+1-b.buf.Len())])
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		rec := b.rec
+		rec.ResponseBytes = b.n
+		rec.DurationMS = ms(time.Since(b.start))
+		body, cut := b.t.clip(b.buf.Bytes())
+		rec.ResponseBody = redactText(body, b.ctype)
+		rec.Truncated = rec.Truncated || cut
+		b.t.write(rec)
+	})
+	return err
+}
+
+// isText keeps audio and images out of the trace.
+func isText(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "json") || strings.HasSuffix(mt, "xml") ||
+		strings.HasSuffix(mt, "javascript") || mt == "application/x-www-form-urlencoded"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
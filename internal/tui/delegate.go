@@ -14,10 +14,12 @@ import (
 
 type resultDelegate struct {
 	compact bool
+	// selected is shared with the model; keyed by track ID.
+	selected map[string]bool
 }
 
-func newResultDelegate() *resultDelegate {
-	return &resultDelegate{}
+func newResultDelegate(selected map[string]bool) *resultDelegate {
+	return &resultDelegate{selected: selected}
 }
 
 func (d *resultDelegate) Height() int {
@@ -67,6 +69,14 @@ func (d *resultDelegate) Render(w io.Writer, m list.Model, index int, item list.
 		descStyle = lipgloss.NewStyle().Foreground(colorMuted)
 	}
 
+	if len(d.selected) > 0 {
+		mark := "○ "
+		if d.selected[it.ID] {
+			mark = "● "
+		}
+		prefix += mark
+	}
+
 	textW := m.Width() - lipgloss.Width(prefix)
 	if textW < 0 {
 		textW = 0
@@ -98,6 +108,6 @@ func (d *resultDelegate) Render(w io.Writer, m list.Model, index int, item list.
 	}
 
 	line1 := prefixStyle.Render(prefix) + titleStyle.Render(title)
-	line2 := prefixStyle.Render("  ") + descStyle.Render(desc)
+	line2 := prefixStyle.Render(strings.Repeat(" ", lipgloss.Width(prefix))) + descStyle.Render(desc)
 	_, _ = fmt.Fprintf(w, "%s\n%s", line1, line2)
 }
@@ -1,10 +1,13 @@
 package tui
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,6 +22,7 @@ import (
 
 	"kotodama-kamataichi/internal/download"
 	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
 )
 
 type screen int
@@ -33,6 +37,7 @@ const (
 const (
 	focusAPIKey = iota
 	focusKeyword
+	focusSource
 	focusPlatform
 	focusQuality
 	focusOutput
@@ -50,9 +55,10 @@ type model struct {
 	qualities []string
 	platIdx   int
 	qualIdx   int
+	srcIdx    int
 
-	apiKey     textinput.Model
-	keyword    textinput.Model
+	apiKey      textinput.Model
+	keyword     textinput.Model
 	outDirInput textinput.Model
 
 	list     list.Model
@@ -67,16 +73,45 @@ type model struct {
 	errMsg   string
 	status   string
 
-	dlCh    chan tea.Msg
-	dlBytes int64
-	dlTotal int64
+	dlCh     chan tea.Msg
+	dlCancel context.CancelFunc
+	dlBytes  int64
+	dlTotal  int64
+	dlMeter  rateMeter
+
+	// coll is the open collection, nil for plain search results.
+	coll        *tunehub.Collection
+	selected    map[string]bool
+	playlistFmt string
+	batchTotal  int
+	batchDone   int
+	batchFailed int
+	batchBytes  map[int][2]int64
+	warns       *warnBox
+	ledger      *usage.Ledger
+	// ledgerReady is set by the first usageMsg, once the ledger has read
+	// its file off the UI goroutine; until then the header shows no cost.
+	ledgerReady bool
+	costToday   float64
+
+	query searchQuery
+	// cachedAt is when the shown search results were fetched, zero if live.
+	cachedAt time.Time
 
 	lastResult download.Result
 }
 
+type searchQuery struct {
+	platform string
+	keyword  string
+}
+
 type searchResultMsg struct {
+	query searchQuery
 	items []tunehub.SearchItem
 	err   error
+	// refresh marks a background update of results already on screen.
+	refresh bool
 }
 
 type downloadProgressMsg struct {
@@ -90,6 +125,11 @@ type downloadDoneMsg struct {
 	err error
 }
 
+// usageMsg carries the ledger's total for today.
+type usageMsg struct {
+	today float64
+}
+
 type listItem tunehub.SearchItem
 
 func (i listItem) Title() string {
@@ -114,7 +154,10 @@ func (i listItem) FilterValue() string {
 	return strings.TrimSpace(i.Name + " " + i.Artist + " " + i.Album + " " + i.ID)
 }
 
-func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
+// New builds the TUI. playlistFormat ("m3u8", "xspf" or empty) controls the
+// playlist written after each batch. ledger may be nil; it feeds the cost
+// shown in the header.
+func New(th *tunehub.Client, dl *download.Downloader, outDir, playlistFormat string, ledger *usage.Ledger) tea.Model {
 	api := textinput.New()
 	api.Placeholder = "TUNEHUB API Key (th_...)"
 	api.Prompt = "API Key:  "
@@ -123,7 +166,7 @@ func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
 	api.SetValue(strings.TrimSpace(os.Getenv("TUNEHUB_API_KEY")))
 
 	kw := textinput.New()
-	kw.Placeholder = "Keyword"
+	kw.Placeholder = "Keyword, ID or share link"
 	kw.Prompt = "Search:   "
 
 	od := textinput.New()
@@ -143,7 +186,8 @@ func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
 	sp.Spinner = spinner.MiniDot
 	sp.Style = lipgloss.NewStyle().Foreground(colorCyan)
 
-	del := newResultDelegate()
+	selected := map[string]bool{}
+	del := newResultDelegate(selected)
 	l := list.New(nil, del, 0, 0)
 	// We render our own header/footer. Keep list internals lean.
 	l.SetShowTitle(false)
@@ -197,7 +241,7 @@ func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
 		dl:          dl,
 		w:           80,
 		h:           24,
-		platforms:   []string{"netease", "qq", "kuwo"},
+		platforms:   slices.Clone(tunehub.Platforms),
 		qualities:   []string{"320k", "128k", "flac", "flac24bit"},
 		focusIdx:    initFocus,
 		apiKey:      api,
@@ -208,7 +252,24 @@ func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
 		delegate:    del,
 		picker:      fp,
 		progress:    p,
-		screen:    screenSearch,
+		screen:      screenSearch,
+		selected:    selected,
+		playlistFmt: playlistFormat,
+		warns:       &warnBox{},
+		ledger:      ledger,
+	}
+	if th != nil {
+		th.Warn = m.warns.add
+		// User-defined platforms go after TuneHub's; configs that didn't load
+		// are shown on the first screen.
+		m.platforms = append(m.platforms, th.Overrides.Platforms()...)
+		var skipped []string
+		for _, err := range th.Overrides.Errs {
+			skipped = append(skipped, err.Error())
+		}
+		if len(skipped) > 0 {
+			m.errMsg = "Method configs skipped: " + strings.Join(skipped, "; ")
+		}
 	}
 	m.applyInputStyles()
 	m.onResize()
@@ -216,7 +277,19 @@ func New(th *tunehub.Client, dl *download.Downloader, outDir string) tea.Model {
 }
 
 func (m *model) Init() tea.Cmd {
-	return tea.Batch(textinput.Blink, m.spinner.Tick)
+	return tea.Batch(textinput.Blink, m.spinner.Tick, usageCmd(m.ledger))
+}
+
+// usageCmd reads today's cost off the UI goroutine; the first call also
+// loads usage.jsonl. It runs at startup and after every download, so View
+// only ever shows the cached value.
+func usageCmd(l *usage.Ledger) tea.Cmd {
+	if l == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return usageMsg{today: l.Today()}
+	}
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -230,6 +303,15 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.String() == "ctrl+c" {
 			return m, tea.Quit
 		}
+	case searchResultMsg:
+		if msg.refresh {
+			m.onRefresh(msg)
+			return m, nil
+		}
+	case usageMsg:
+		m.ledgerReady = true
+		m.costToday = msg.today
+		return m, nil
 	}
 
 	switch m.screen {
@@ -270,17 +352,25 @@ func (m *model) updateSearch(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case searchResultMsg:
 			m.loading = false
 			if msg.err != nil {
-				m.errMsg = msg.err.Error()
+				m.errMsg = errText(msg.err)
 				return m, nil
 			}
-			items := make([]list.Item, 0, len(msg.items))
-			for _, it := range msg.items {
-				items = append(items, listItem(it))
+			m.coll = nil
+			m.query = msg.query
+			m.cachedAt = time.Time{}
+			m.showResults(msg.items)
+			return m, nil
+		case collectionResultMsg:
+			m.loading = false
+			if msg.err != nil {
+				m.errMsg = errText(msg.err)
+				return m, nil
 			}
-			m.list.SetItems(items)
-			m.errMsg = ""
-			m.screen = screenResults
-			m.onResize()
+			m.coll = &msg.coll
+			m.showResults(msg.coll.Tracks)
+			return m, nil
+		case linkResultMsg:
+			m.onLinkResult(msg)
 			return m, nil
 		}
 		return m, m.spinner.Tick
@@ -305,6 +395,8 @@ func (m *model) updateSearch(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.platIdx = (m.platIdx + len(m.platforms) - 1) % len(m.platforms)
 			case focusQuality:
 				m.qualIdx = (m.qualIdx + len(m.qualities) - 1) % len(m.qualities)
+			case focusSource:
+				m.srcIdx = (m.srcIdx + len(sources) - 1) % len(sources)
 			}
 		case "right":
 			switch m.focusIdx {
@@ -312,6 +404,8 @@ func (m *model) updateSearch(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.platIdx = (m.platIdx + 1) % len(m.platforms)
 			case focusQuality:
 				m.qualIdx = (m.qualIdx + 1) % len(m.qualities)
+			case focusSource:
+				m.srcIdx = (m.srcIdx + 1) % len(sources)
 			}
 		case "b":
 			if m.focusIdx == focusOutput {
@@ -328,14 +422,37 @@ func (m *model) updateSearch(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "enter":
 			kw := strings.TrimSpace(m.keyword.Value())
 			if kw == "" {
-				m.errMsg = "Keyword required"
+				if m.srcIdx > 0 {
+					m.errMsg = strings.ToUpper(sources[m.srcIdx][:1]) + sources[m.srcIdx][1:] + " ID required"
+				} else {
+					m.errMsg = "Keyword required"
+				}
 				return m, nil
 			}
 			m.errMsg = ""
 			m.status = ""
 			m.loading = true
 			plat := m.platforms[m.platIdx]
-			cmds = append(cmds, searchCmd(m.th, plat, kw))
+			switch {
+			case tunehub.LooksLikeLink(kw):
+				cmds = append(cmds, linkCmd(m.th, kw))
+			case m.srcIdx > 0:
+				cmds = append(cmds, collectionCmd(m.th, plat, sources[m.srcIdx], kw))
+			default:
+				q := searchQuery{platform: plat, keyword: kw}
+				if hit, ok := m.th.CachedSearch(plat, kw, 1, searchLimit); ok {
+					m.loading = false
+					m.coll = nil
+					m.query = q
+					m.cachedAt = hit.FetchedAt
+					m.showResults(hit.Items)
+					if hit.Stale {
+						return m, refreshCmd(m.th, q)
+					}
+					return m, nil
+				}
+				cmds = append(cmds, searchCmd(m.th, q))
+			}
 			cmds = append(cmds, m.spinner.Tick)
 		}
 	}
@@ -362,6 +479,22 @@ func (m *model) updateResults(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.list.FilterState() == list.Unfiltered {
 				return m, tea.Quit
 			}
+		case " ":
+			if m.list.FilterState() != list.Filtering {
+				m.toggleSelected()
+				return m, nil
+			}
+		case "a":
+			if m.list.FilterState() != list.Filtering {
+				m.toggleAll()
+				return m, nil
+			}
+		case "r":
+			if m.list.FilterState() != list.Filtering && m.coll == nil && m.query.keyword != "" {
+				m.status = "Refreshing..."
+				m.onResize()
+				return m, refreshCmd(m.th, m.query)
+			}
 		case "enter":
 			if m.list.FilterState() == list.Filtering {
 				break
@@ -375,13 +508,17 @@ func (m *model) updateResults(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			apiKey := strings.TrimSpace(m.apiKey.Value())
-			if apiKey == "" {
+			plat := m.platforms[m.platIdx]
+			if apiKey == "" && m.th.NeedsKey(plat) {
 				m.errMsg = "API key required to download (press b to go back)"
 				return m, nil
 			}
-			plat := m.platforms[m.platIdx]
 			qual := m.qualities[m.qualIdx]
-			m.startDownload(apiKey, plat, qual, tunehub.SearchItem(it))
+			if picked := m.selectedItems(); len(picked) > 0 {
+				m.startBatch(apiKey, plat, qual, picked)
+			} else {
+				m.startDownload(apiKey, plat, qual, tunehub.SearchItem(it))
+			}
 			m.onResize()
 			return m, listenMsg(m.dlCh)
 		}
@@ -401,32 +538,66 @@ func (m *model) updateDownloading(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.kind == "audio" {
 			m.dlBytes = msg.bytes
 			m.dlTotal = msg.total
+			m.dlMeter.add(msg.bytes, time.Now())
 		}
 		return m, listenMsg(m.dlCh)
+	case batchProgressMsg:
+		m.onBatchProgress(msg)
+		return m, listenMsg(m.dlCh)
+	case batchItemDoneMsg:
+		m.onBatchItemDone(msg)
+		return m, listenMsg(m.dlCh)
+	case batchDoneMsg:
+		m.onBatchDone(msg)
+		return m, usageCmd(m.ledger)
 	case downloadDoneMsg:
 		m.dlCh = nil
+		m.dlCancel = nil
 		m.loading = false
+		// The parse may have been paid for even if the download failed.
+		refresh := usageCmd(m.ledger)
+		if errors.Is(msg.err, context.Canceled) {
+			m.status = "Download cancelled"
+			m.errMsg = ""
+			m.screen = screenResults
+			m.onResize()
+			return m, refresh
+		}
 		if msg.err != nil {
-			m.errMsg = msg.err.Error()
+			m.errMsg = errText(msg.err)
 			m.screen = screenResults
 			m.onResize()
-			return m, nil
+			return m, refresh
 		}
 		m.lastResult = msg.res
 		m.status = "Download complete: " + msg.res.Dir
+		if len(msg.res.Warnings) > 0 {
+			m.status += " (warning: " + strings.Join(msg.res.Warnings, "; ") + ")"
+		}
 		m.errMsg = ""
 		m.screen = screenResults
 		m.onResize()
-		return m, nil
+		return m, refresh
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "esc":
 			return m, tea.Quit
 		case "b":
-			m.errMsg = "Cancel not implemented (download continues in background)"
-			m.screen = screenResults
-			m.onResize()
-			return m, nil
+			// The worker cleans up its staging dir and reports back via downloadDoneMsg.
+			if m.dlCancel != nil {
+				m.dlCancel()
+				m.status = "Cancelling..."
+			}
+			return m, listenMsg(m.dlCh)
+		case "+", "=":
+			stepLimit(m.dl.Limiter, 1)
+			return m, listenMsg(m.dlCh)
+		case "-":
+			stepLimit(m.dl.Limiter, -1)
+			return m, listenMsg(m.dlCh)
+		case "a":
+			m.dl.Limiter.ClearRate()
+			return m, listenMsg(m.dlCh)
 		}
 	}
 
@@ -485,13 +656,18 @@ func (m *model) startDownload(apiKey, platform, quality string, it tunehub.Searc
 	m.status = fmt.Sprintf("Parse and download: %s - %s", it.Name, it.Artist)
 	m.dlBytes = 0
 	m.dlTotal = 0
+	m.dlMeter.reset()
+	m.batchTotal = 0
 	m.onResize()
 
 	ch := make(chan tea.Msg, 128)
 	m.dlCh = ch
+	// Cancel-only: a slow download may take minutes, and stalled transfers
+	// are caught by the Downloader.
+	ctx, cancel := context.WithCancel(context.Background())
+	m.dlCancel = cancel
 
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 		defer cancel()
 
 		pd, err := m.th.Parse(ctx, apiKey, platform, it.ID, quality)
@@ -515,6 +691,8 @@ func (m *model) startDownload(apiKey, platform, quality string, it tunehub.Searc
 			close(ch)
 			return
 		}
+		// Translated/romanized lyrics are a bonus; don't fail the download over them.
+		lyricsErr := m.th.AttachLyrics(ctx, platform, &pi)
 
 		res, err := m.dl.DownloadSong(ctx, m.outDirInput.Value(), pi, func(p download.Progress) {
 			select {
@@ -522,6 +700,9 @@ func (m *model) startDownload(apiKey, platform, quality string, it tunehub.Searc
 			default:
 			}
 		})
+		if lyricsErr != nil {
+			res.Warnings = append(res.Warnings, "lyrics: "+lyricsErr.Error())
+		}
 		ch <- downloadDoneMsg{res: res, err: err}
 		close(ch)
 	}()
@@ -540,12 +721,24 @@ func listenMsg(ch <-chan tea.Msg) tea.Cmd {
 	}
 }
 
-func searchCmd(th *tunehub.Client, platform, keyword string) tea.Cmd {
+const searchLimit = 20
+
+func searchCmd(th *tunehub.Client, q searchQuery) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
-		items, err := th.Search(ctx, platform, keyword, 1, 20)
-		return searchResultMsg{items: items, err: err}
+		items, err := th.Search(ctx, q.platform, q.keyword, 1, searchLimit)
+		return searchResultMsg{query: q, items: items, err: err}
+	}
+}
+
+// refreshCmd re-runs q upstream while the cached results stay on screen.
+func refreshCmd(th *tunehub.Client, q searchQuery) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		items, err := th.SearchFresh(ctx, q.platform, q.keyword, 1, searchLimit)
+		return searchResultMsg{query: q, items: items, err: err, refresh: true}
 	}
 }
 
@@ -557,11 +750,12 @@ func (m *model) viewSearch() string {
 	}
 
 	left := headerTitleStyle.Render(">> kotodama-kamataichi") + headerSubStyle.Render(" // search")
-	right := headerLabelStyle.Render("P:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.platforms[m.platIdx]) + headerFillStyle.Render("  ") + headerLabelStyle.Render("Q:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.qualities[m.qualIdx])
+	right := m.headerRight()
 
 	form := strings.Join([]string{
 		m.apiKey.View(),
 		m.keyword.View(),
+		renderSelector("Source:   ", sources, m.srcIdx, m.focusIdx == focusSource),
 		renderSelector("Platform: ", m.platforms, m.platIdx, m.focusIdx == focusPlatform),
 		renderSelector("Quality:  ", m.qualities, m.qualIdx, m.focusIdx == focusQuality),
 		m.outDirInput.View(),
@@ -594,8 +788,14 @@ func (m *model) viewResults() string {
 		return container.Render("Terminal too small. Press q to quit.")
 	}
 
-	left := headerTitleStyle.Render(">> kotodama-kamataichi") + headerSubStyle.Render(" // results")
-	right := headerLabelStyle.Render("P:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.platforms[m.platIdx]) + headerFillStyle.Render("  ") + headerLabelStyle.Render("Q:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.qualities[m.qualIdx])
+	sub := " // results"
+	if m.coll != nil {
+		sub = " // " + m.coll.Kind + ": " + cmp.Or(m.coll.Name, m.coll.ID)
+	} else if !m.cachedAt.IsZero() {
+		sub += " (cached " + formatAge(time.Since(m.cachedAt)) + ")"
+	}
+	left := headerTitleStyle.Render(">> kotodama-kamataichi") + headerSubStyle.Render(sub)
+	right := m.headerRight()
 
 	listView := m.list.View()
 	if len(m.list.Items()) == 0 {
@@ -613,7 +813,15 @@ func (m *model) viewResults() string {
 	if m.errMsg != "" {
 		lines = append(lines, renderErrorLine(m.errMsg))
 	}
-	lines = append(lines, renderFooterKeys(w, "Enter", "download", "/", "filter", "b", "back", "Esc", "quit"))
+	if n := len(m.selectedItems()); n > 0 {
+		lines = append(lines, renderFooterKeys(w, "Enter", fmt.Sprintf("download %d", n), "Space", "select", "a", "all/none", "b", "back", "Esc", "quit"))
+	} else {
+		keys := []string{"Enter", "download", "Space", "select", "a", "all", "/", "filter"}
+		if m.coll == nil && !m.cachedAt.IsZero() {
+			keys = append(keys, "r", "refresh")
+		}
+		lines = append(lines, renderFooterKeys(w, append(keys, "b", "back", "Esc", "quit")...))
+	}
 
 	return container.Render(strings.Join(filterEmpty(lines), "\n"))
 }
@@ -626,7 +834,7 @@ func (m *model) viewDownloading() string {
 	}
 
 	left := headerTitleStyle.Render(">> kotodama-kamataichi") + headerSubStyle.Render(" // downloading")
-	right := headerLabelStyle.Render("P:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.platforms[m.platIdx]) + headerFillStyle.Render("  ") + headerLabelStyle.Render("Q:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.qualities[m.qualIdx])
+	right := m.headerRight()
 
 	pct := percent(m.dlBytes, m.dlTotal)
 	bar := m.progress.ViewAs(pct)
@@ -638,17 +846,38 @@ func (m *model) viewDownloading() string {
 		bytesLine = faintStyle.Render(bytesLine)
 	}
 
+	speedLine := labelStyle.Render("Speed: ") + valueStyle.Render(formatBytes(int64(m.dlMeter.rate()))+"/s")
+	if eta, ok := m.dlMeter.eta(m.dlBytes, m.dlTotal); ok {
+		speedLine += labelStyle.Render("  ETA: ") + valueStyle.Render(formatETA(eta))
+	}
+	limit, manual := m.dl.Limiter.Rate()
+	limitText := formatRate(limit)
+	if manual {
+		limitText += " (manual)"
+	}
+	speedLine += labelStyle.Render("  Limit: ") + valueStyle.Render(limitText)
+
+	batchLine := ""
+	if m.batchTotal > 0 {
+		batchLine = labelStyle.Render("Tracks: ") + valueStyle.Render(fmt.Sprintf("%d/%d", m.batchDone, m.batchTotal))
+		if m.batchFailed > 0 {
+			batchLine += labelStyle.Render("  Failed: ") + valueStyle.Render(fmt.Sprint(m.batchFailed))
+		}
+	}
+
 	panel := renderPanel("", w, strings.Join(filterEmpty([]string{
 		renderInfoLine(m.spinner.View() + " " + m.status),
 		progLine,
 		bytesLine,
+		speedLine,
+		batchLine,
 	}), "\n"))
 
 	lines := []string{
 		renderHeader(w, left, right),
 		renderDivider(w),
 		panel,
-		renderFooterKeys(w, "b", "back", "Esc", "quit"),
+		renderFooterKeys(w, "+/-", "limit", "a", "auto limit", "b", "cancel", "Esc", "quit"),
 	}
 	return container.Render(strings.Join(filterEmpty(lines), "\n"))
 }
@@ -778,6 +1007,43 @@ func (m *model) onResize() {
 	m.picker.SetHeight(pickerH)
 }
 
+func (m *model) showResults(items []tunehub.SearchItem) {
+	listItems := make([]list.Item, 0, len(items))
+	for _, it := range items {
+		listItems = append(listItems, listItem(it))
+	}
+	clear(m.selected)
+	m.status = ""
+	if w := m.warns.take(); len(w) > 0 {
+		m.status = "Warning: " + strings.Join(w, "; ")
+	}
+	m.list.ResetFilter()
+	m.list.SetItems(listItems)
+	m.list.Select(0)
+	m.errMsg = ""
+	m.screen = screenResults
+	m.onResize()
+}
+
+func (m *model) headerRight() string {
+	right := headerLabelStyle.Render("P:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.platforms[m.platIdx]) + headerFillStyle.Render("  ") + headerLabelStyle.Render("Q:") + headerFillStyle.Render(" ") + headerValueStyle.Render(m.qualities[m.qualIdx])
+	if m.ledgerReady {
+		right += headerFillStyle.Render("  ") + headerLabelStyle.Render("Cost:") + headerFillStyle.Render(" ") + headerValueStyle.Render(fmt.Sprintf("%g today", m.costToday))
+	}
+	return right
+}
+
+// errText leads with the typed error's hint, if any, and keeps the raw error
+// in parentheses for bug reports.
+func errText(err error) string {
+	var h interface{ Hint() string }
+	if errors.As(err, &h) && h.Hint() != "" {
+		hint := h.Hint()
+		return strings.ToUpper(hint[:1]) + hint[1:] + " (" + err.Error() + ")"
+	}
+	return err.Error()
+}
+
 func filterEmpty(lines []string) []string {
 	out := make([]string, 0, len(lines))
 	for _, s := range lines {
@@ -0,0 +1,381 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"kotodama-kamataichi/internal/download"
+	"kotodama-kamataichi/internal/playlist"
+	"kotodama-kamataichi/internal/tunehub"
+	"kotodama-kamataichi/internal/usage"
+)
+
+var sources = []string{"song", tunehub.KindPlaylist, tunehub.KindAlbum, tunehub.KindArtist}
+
+type collectionResultMsg struct {
+	coll tunehub.Collection
+	err  error
+}
+
+type batchProgressMsg struct {
+	index int
+	bytes int64
+	total int64
+}
+
+type batchItemDoneMsg struct {
+	res download.BatchResult
+}
+
+type batchDoneMsg struct {
+	results     []download.BatchResult
+	parseFailed int
+	playlist    string
+	warnings    []string
+	err         error
+}
+
+type linkResultMsg struct {
+	link tunehub.Link
+	coll *tunehub.Collection
+	err  error
+}
+
+// linkCmd resolves a pasted share link and, for collections, loads the
+// tracks right away.
+func linkCmd(th *tunehub.Client, raw string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		link, err := th.ResolveLink(ctx, raw)
+		if err != nil || link.Kind == tunehub.KindSong {
+			return linkResultMsg{link: link, err: err}
+		}
+		coll, err := th.OpenCollection(ctx, link.Platform, link.Kind, link.ID)
+		return linkResultMsg{link: link, coll: &coll, err: err}
+	}
+}
+
+func (m *model) onLinkResult(msg linkResultMsg) {
+	m.loading = false
+	if msg.err != nil {
+		m.errMsg = errText(msg.err)
+		return
+	}
+	idx := -1
+	for i, p := range m.platforms {
+		if p == msg.link.Platform {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		m.errMsg = "Platform not supported: " + msg.link.Platform
+		return
+	}
+	m.platIdx = idx
+	m.coll = msg.coll
+	if msg.coll != nil {
+		m.showResults(msg.coll.Tracks)
+		return
+	}
+	m.showResults([]tunehub.SearchItem{{ID: msg.link.ID}})
+	resolved := fmt.Sprintf("Resolved %s song %s", msg.link.Platform, msg.link.ID)
+	if m.status != "" {
+		resolved += " (" + m.status + ")"
+	}
+	m.status = resolved
+}
+
+func collectionCmd(th *tunehub.Client, platform, kind, id string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		coll, err := th.OpenCollection(ctx, platform, kind, id)
+		return collectionResultMsg{coll: coll, err: err}
+	}
+}
+
+// selectedItems returns the marked tracks in list order.
+func (m *model) selectedItems() []tunehub.SearchItem {
+	var out []tunehub.SearchItem
+	for _, li := range m.list.Items() {
+		it, ok := li.(listItem)
+		if ok && m.selected[it.ID] {
+			out = append(out, tunehub.SearchItem(it))
+		}
+	}
+	return out
+}
+
+func (m *model) toggleSelected() {
+	it, ok := m.list.SelectedItem().(listItem)
+	if !ok {
+		return
+	}
+	if m.selected[it.ID] {
+		delete(m.selected, it.ID)
+	} else {
+		m.selected[it.ID] = true
+	}
+}
+
+// toggleAll selects every visible item, or clears the selection when all of
+// them are already selected.
+func (m *model) toggleAll() {
+	items := m.list.VisibleItems()
+	all := len(items) > 0
+	for _, li := range items {
+		if it, ok := li.(listItem); ok && !m.selected[it.ID] {
+			all = false
+			break
+		}
+	}
+	for _, li := range items {
+		it, ok := li.(listItem)
+		if !ok {
+			continue
+		}
+		if all {
+			delete(m.selected, it.ID)
+		} else {
+			m.selected[it.ID] = true
+		}
+	}
+}
+
+func (m *model) playlistName() string {
+	if m.coll != nil && strings.TrimSpace(m.coll.Name) != "" {
+		return download.SanitizeName(m.coll.Name)
+	}
+	return "batch-" + time.Now().Format("20060102-150405")
+}
+
+func (m *model) startBatch(apiKey, platform, quality string, items []tunehub.SearchItem) {
+	m.screen = screenDownloading
+	m.loading = true
+	m.errMsg = ""
+	m.status = fmt.Sprintf("Parsing %d tracks...", len(items))
+	m.dlBytes = 0
+	m.dlTotal = 0
+	m.dlMeter.reset()
+	m.batchTotal = len(items)
+	m.batchDone = 0
+	m.batchFailed = 0
+	m.batchBytes = map[int][2]int64{}
+	m.onResize()
+
+	ch := make(chan tea.Msg, 128)
+	m.dlCh = ch
+	ctx, cancel := context.WithCancel(context.Background())
+	m.dlCancel = cancel
+
+	outDir := m.outDirInput.Value()
+	plName := m.playlistName()
+	format := m.playlistFmt
+	ctx = usage.WithBatch(ctx, plName)
+
+	go func() {
+		defer cancel()
+		defer close(ch)
+
+		ids := make([]string, len(items))
+		for i, it := range items {
+			ids[i] = it.ID
+		}
+		var parsed []tunehub.ParseItem
+		var parseWarnings []string
+		var parseErr error
+		failed := 0
+		m.th.ParseChunks(ctx, apiKey, platform, ids, quality, func(chunk []string, pd tunehub.ParseData, err error) {
+			if err != nil {
+				failed += len(chunk)
+				parseErr = err
+				parseWarnings = append(parseWarnings, fmt.Sprintf("parse of %d tracks: %v", len(chunk), err))
+				return
+			}
+			for _, pi := range pd.Data {
+				if !pi.Success {
+					failed++
+					continue
+				}
+				if err := m.th.AttachLyrics(ctx, platform, &pi); err != nil {
+					parseWarnings = append(parseWarnings, pi.ID+": lyrics: "+err.Error())
+				}
+				parsed = append(parsed, pi)
+			}
+		})
+		if len(parsed) == 0 {
+			if parseErr == nil {
+				parseErr = errors.New("no track could be parsed")
+			}
+			ch <- batchDoneMsg{parseFailed: failed, err: parseErr}
+			return
+		}
+
+		results, warnings, err := m.dl.DownloadBatch(ctx, outDir, parsed, func(ev download.BatchEvent) {
+			var msg tea.Msg
+			switch {
+			case ev.Done:
+				// Completion events must not be dropped; the counters depend on them.
+				ch <- batchItemDoneMsg{res: ev.Result}
+				return
+			case ev.Progress.Kind == "audio":
+				msg = batchProgressMsg{index: ev.Index, bytes: ev.Progress.Bytes, total: ev.Progress.Total}
+			default:
+				return
+			}
+			select {
+			case ch <- msg:
+			default:
+			}
+		})
+		done := batchDoneMsg{results: results, parseFailed: failed, warnings: append(parseWarnings, warnings...), err: err}
+		if format != "" && len(playlist.FromBatch(results)) > 0 {
+			p := filepath.Join(outDir, plName+playlist.Ext(format))
+			if perr := playlist.WriteFile(p, format, playlist.FromBatch(results)); perr != nil {
+				done.warnings = append(done.warnings, "playlist: "+perr.Error())
+			} else {
+				done.playlist = p
+			}
+		}
+		ch <- done
+	}()
+}
+
+func (m *model) onBatchProgress(msg batchProgressMsg) {
+	m.batchBytes[msg.index] = [2]int64{msg.bytes, msg.total}
+	m.dlBytes, m.dlTotal = 0, 0
+	for _, v := range m.batchBytes {
+		m.dlBytes += v[0]
+		m.dlTotal += v[1]
+	}
+	m.dlMeter.add(m.dlBytes, time.Now())
+}
+
+func (m *model) onBatchItemDone(msg batchItemDoneMsg) {
+	m.batchDone++
+	if msg.res.Err != nil {
+		m.batchFailed++
+	}
+	m.status = fmt.Sprintf("Downloaded %d/%d: %s - %s", m.batchDone, m.batchTotal, msg.res.Item.Info.Name, msg.res.Item.Info.Artist)
+}
+
+func (m *model) onBatchDone(msg batchDoneMsg) {
+	m.dlCh = nil
+	m.dlCancel = nil
+	m.loading = false
+	m.screen = screenResults
+	defer m.onResize()
+
+	dlFailed, cancelled := 0, false
+	var firstErr error
+	for _, br := range msg.results {
+		if br.Err != nil {
+			dlFailed++
+			cancelled = cancelled || errors.Is(br.Err, context.Canceled)
+			if firstErr == nil {
+				firstErr = br.Err
+			}
+		}
+	}
+	if errors.Is(msg.err, context.Canceled) {
+		cancelled, msg.err = true, nil
+	}
+	switch {
+	case msg.err != nil:
+		m.errMsg = errText(msg.err)
+	case firstErr != nil && !cancelled:
+		m.errMsg = errText(firstErr)
+	default:
+		m.errMsg = ""
+	}
+	if len(msg.results) == 0 {
+		if cancelled {
+			m.status = "Download cancelled"
+		}
+		return
+	}
+
+	clear(m.selected)
+	m.status = "Batch complete"
+	if cancelled {
+		m.status = "Batch cancelled"
+	}
+	m.status += fmt.Sprintf(": %d downloaded, %d failed", len(msg.results)-dlFailed, dlFailed+msg.parseFailed)
+	if msg.playlist != "" {
+		m.status += ", playlist " + msg.playlist
+	}
+	if len(msg.warnings) > 0 {
+		m.status += " (warning: " + strings.Join(msg.warnings, "; ") + ")"
+	}
+}
+
+func (m *model) onRefresh(msg searchResultMsg) {
+	if m.coll != nil || msg.query != m.query {
+		return
+	}
+	defer m.onResize()
+	if msg.err != nil {
+		if m.status == "Refreshing..." {
+			m.status = ""
+		}
+		m.errMsg = "Refresh failed, showing cached results: " + errText(msg.err)
+		return
+	}
+	cur := ""
+	if it, ok := m.list.SelectedItem().(listItem); ok {
+		cur = it.ID
+	}
+	keep := map[string]bool{}
+	idx := -1
+	listItems := make([]list.Item, 0, len(msg.items))
+	for i, it := range msg.items {
+		listItems = append(listItems, listItem(it))
+		if m.selected[it.ID] {
+			keep[it.ID] = true
+		}
+		if it.ID == cur {
+			idx = i
+		}
+	}
+	clear(m.selected)
+	maps.Copy(m.selected, keep)
+	m.list.SetItems(listItems)
+	if idx >= 0 && m.list.FilterState() == list.Unfiltered {
+		m.list.Select(idx)
+	}
+	m.cachedAt = time.Time{}
+	if m.status == "Refreshing..." {
+		m.status = ""
+	}
+}
+
+// warnBox collects tunehub.Client warnings, which arrive from the command
+// goroutines, until the model shows them.
+type warnBox struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (w *warnBox) add(msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.msgs = append(w.msgs, msg)
+}
+
+func (w *warnBox) take() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msgs := w.msgs
+	w.msgs = nil
+	return msgs
+}
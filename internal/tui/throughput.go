@@ -0,0 +1,71 @@
+package tui
+
+import (
+	"time"
+
+	"kotodama-kamataichi/internal/download"
+)
+
+const rateWindow = 5 * time.Second
+
+// limitSteps are the presets cycled with +/-; 0 (unlimited) sits on top.
+var limitSteps = []int64{256 << 10, 512 << 10, 1 << 20, 2 << 20, 5 << 20, 10 << 20, 0}
+
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// rateMeter derives throughput from the Progress stream over a sliding window.
+type rateMeter struct {
+	samples []rateSample
+}
+
+func (r *rateMeter) reset() {
+	r.samples = r.samples[:0]
+}
+
+func (r *rateMeter) add(bytes int64, at time.Time) {
+	r.samples = append(r.samples, rateSample{at: at, bytes: bytes})
+	cut := 0
+	for cut < len(r.samples)-2 && at.Sub(r.samples[cut].at) > rateWindow {
+		cut++
+	}
+	r.samples = r.samples[cut:]
+}
+
+// rate is in bytes/sec; 0 until there are two samples to compare.
+func (r *rateMeter) rate() float64 {
+	if len(r.samples) < 2 {
+		return 0
+	}
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	dt := last.at.Sub(first.at).Seconds()
+	if dt <= 0 || last.bytes < first.bytes {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / dt
+}
+
+func (r *rateMeter) eta(bytes, total int64) (time.Duration, bool) {
+	rate := r.rate()
+	if total <= 0 || rate <= 0 || bytes >= total {
+		return 0, false
+	}
+	return time.Duration(float64(total-bytes) / rate * float64(time.Second)), true
+}
+
+func stepLimit(l *download.RateLimiter, dir int) {
+	cur, _ := l.Rate()
+	idx := len(limitSteps) - 1
+	if cur > 0 {
+		idx = 0
+		for i, v := range limitSteps[:len(limitSteps)-1] {
+			if v <= cur {
+				idx = i
+			}
+		}
+	}
+	idx = min(max(idx+dir, 0), len(limitSteps)-1)
+	l.SetRate(limitSteps[idx])
+}
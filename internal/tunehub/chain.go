@@ -0,0 +1,93 @@
+package tunehub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"kotodama-kamataichi/internal/jsbox"
+)
+
+var stepNameRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// execConfig runs a single-call config with execMethod, or each step of a
+// chained one in order.
+func (c *Client) execConfig(ctx context.Context, platform string, cfg MethodConfig, vars map[string]any) (any, error) {
+	if len(cfg.Steps) == 0 {
+		return c.execMethod(ctx, platform, cfg, vars)
+	}
+	if t := strings.ToLower(cfg.Type); t != "" && t != "chain" {
+		return nil, fmt.Errorf("config with steps must have type \"chain\", not %q", cfg.Type)
+	}
+
+	names, err := stepNames(cfg.Steps, vars)
+	if err != nil {
+		return nil, err
+	}
+	scope := maps.Clone(vars)
+	if scope == nil {
+		scope = map[string]any{}
+	}
+	outputs := make(map[string]any, len(cfg.Steps))
+	for i, step := range cfg.Steps {
+		out, err := c.execMethod(ctx, platform, step.MethodConfig, scope)
+		if err == nil && strings.TrimSpace(step.Transform) != "" {
+			// A step's own transform picks out what later steps need.
+			if c.JS == nil {
+				return nil, errors.New("jsbox runner not configured")
+			}
+			out, err = c.JS.Transform(ctx, step.Transform, out)
+		}
+		if err != nil {
+			return nil, stepError(names[i], err)
+		}
+		// Templates see numbers the way scripts do; the final transform
+		// gets the outputs as decoded.
+		scope[names[i]] = jsbox.ScriptValue(out)
+		outputs[names[i]] = out
+	}
+	return outputs, nil
+}
+
+// stepNames names each step and checks the names can be used in templates.
+func stepNames(steps []MethodStep, vars map[string]any) ([]string, error) {
+	names := make([]string, len(steps))
+	seen := map[string]bool{}
+	for i, step := range steps {
+		name := strings.TrimSpace(step.Name)
+		if name == "" {
+			name = fmt.Sprintf("step%d", i+1)
+		}
+		switch {
+		case !stepNameRe.MatchString(name):
+			return nil, fmt.Errorf("step %d: name %q is not an identifier", i+1, name)
+		case seen[name]:
+			return nil, fmt.Errorf("step %d: duplicate name %q", i+1, name)
+		case hasVar(vars, name):
+			return nil, fmt.Errorf("step %d: name %q hides a template variable", i+1, name)
+		case len(step.Steps) > 0:
+			return nil, fmt.Errorf("step %s: steps can't be nested", name)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names, nil
+}
+
+// stepError says which step failed, keeping an *Error's classification.
+func stepError(name string, err error) error {
+	var te *Error
+	if errors.As(err, &te) {
+		te.Op = strings.TrimSpace(te.Op + " step " + name)
+		return err
+	}
+	return fmt.Errorf("step %s: %w", name, err)
+}
+
+func hasVar(vars map[string]any, name string) bool {
+	_, ok := vars[name]
+	return ok
+}
@@ -7,64 +7,77 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"kotodama-kamataichi/internal/jsbox"
+	"kotodama-kamataichi/internal/netroute"
 	"kotodama-kamataichi/internal/template"
 )
 
-const defaultBaseURL = "https://tunehub.sayqz.com/api"
+const DefaultBaseURL = "https://tunehub.sayqz.com/api"
 
 type Client struct {
 	BaseURL string
-	HTTP    *http.Client
-	HTTPv4  *http.Client
-	JS      *jsbox.Runner
+	// HTTP sends requests when Routes is nil.
+	HTTP *http.Client
+	// Routes picks per-host transport settings and rewrites.
+	Routes *netroute.Router
+	// LinkHTTP follows share-link redirects in ResolveLink; nil sends every
+	// hop through Routes.
+	LinkHTTP *http.Client
+	JS       *jsbox.Runner
+	// Methods caches method configs; nil fetches them on every call.
+	Methods *MethodCache
+	// Searches caches search results; nil disables it.
+	Searches *SearchCache
+	// Retry applies to every TuneHub and upstream call; the zero value tries
+	// once.
+	Retry RetryPolicy
+	// Breaker fails calls to a dead host fast; nil disables it.
+	Breaker *Breaker
+	// Meter sees every Parse call that reaches TuneHub; nil skips accounting.
+	Meter ParseMeter
+	// Parses reuses earlier parse results; nil always asks TuneHub.
+	Parses *ParseCache
+	// Overrides replace TuneHub's method configs and add platforms; nil
+	// uses TuneHub's only.
+	Overrides *Overrides
+	// Pins checks TuneHub's method configs against accepted hashes; nil
+	// runs whatever TuneHub sends.
+	Pins *Pins
+	// Warn receives non-fatal problems such as a stale config being served.
+	// It may be called from any goroutine.
+	Warn func(string)
+
+	jarsMu sync.Mutex
+	jars   map[string]http.CookieJar
 }
 
+// DefaultTimeout bounds a request no route gives its own timeout.
+const DefaultTimeout = 25 * time.Second
+
 func New(js *jsbox.Runner) *Client {
-	timeout := 25 * time.Second
 	return &Client{
-		BaseURL: defaultBaseURL,
-		HTTP:    newHTTPClient(timeout, ""),
-		HTTPv4:  newHTTPClient(timeout, "tcp4"),
+		BaseURL: DefaultBaseURL,
+		HTTP:    &http.Client{Timeout: DefaultTimeout},
+		Routes:  netroute.Default(DefaultTimeout),
 		JS:      js,
+		Retry:   DefaultRetry,
+		Breaker: NewBreaker(3, 30*time.Second),
 	}
 }
 
-func newHTTPClient(timeout time.Duration, dialNetwork string) *http.Client {
-	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
-	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if dialNetwork != "" {
-				network = dialNetwork
-			}
-			return dialer.DialContext(ctx, network, addr)
-		},
-		TLSHandshakeTimeout:   10 * time.Second,
-		ResponseHeaderTimeout: 15 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-	}
-	if dialNetwork != "" {
-		// Stick to HTTP/1.1 when forcing IPv4; avoids some HTTP/2 edge cases.
-		tr.ForceAttemptHTTP2 = false
-	}
-	return &http.Client{Timeout: timeout, Transport: tr}
-}
-
 func (c *Client) GetMethods(ctx context.Context) (map[string][]string, error) {
 	var resp APIResponse[map[string][]string]
-	if err := c.getJSON(ctx, "/v1/methods", &resp); err != nil {
+	if err := c.getJSON(ctx, "methods", "/v1/methods", &resp); err != nil {
 		return nil, err
 	}
 	if resp.Code != 0 {
-		return nil, fmt.Errorf("tunehub: %s", resp.Message)
+		return nil, apiError("methods", resp.Code, resp.Message)
 	}
 	return resp.Data, nil
 }
@@ -73,113 +86,279 @@ func (c *Client) GetMethodConfig(ctx context.Context, platform, function string)
 	platform = strings.TrimSpace(platform)
 	function = strings.TrimSpace(function)
 	if platform == "" || function == "" {
-		return MethodConfig{}, errors.New("platform/function required")
+		return MethodConfig{}, &Error{Reason: ReasonInvalid, Op: "method config", Message: "platform/function required"}
 	}
-	var resp APIResponse[MethodConfig]
-	if err := c.getJSON(ctx, "/v1/methods/"+url.PathEscape(platform)+"/"+url.PathEscape(function), &resp); err != nil {
+	if cfg, ok := c.Overrides.Config(platform, function); ok {
+		return cfg, nil
+	}
+	if c.Overrides.Custom(platform) {
+		return MethodConfig{}, &Error{Reason: ReasonConfig, Op: "method config", Platform: platform, Function: function,
+			Message: "no " + function + ".json for this user-defined platform", Err: ErrNoMethod}
+	}
+	var cfg MethodConfig
+	var err error
+	if c.Methods != nil {
+		cfg, err = c.cachedMethodConfig(ctx, platform, function)
+	} else {
+		cfg, err = c.RemoteMethodConfig(ctx, platform, function)
+	}
+	if err != nil {
 		return MethodConfig{}, err
 	}
-	if resp.Code != 0 {
-		return MethodConfig{}, fmt.Errorf("tunehub: %s", resp.Message)
+	if err := c.checkPin(platform, function, cfg); err != nil {
+		return MethodConfig{}, err
 	}
-	return resp.Data, nil
+	return cfg, nil
+}
+
+// RemoteMethodConfig fetches TuneHub's current config, bypassing overrides,
+// pins and the cache.
+func (c *Client) RemoteMethodConfig(ctx context.Context, platform, function string) (MethodConfig, error) {
+	e, _, err := c.fetchMethodConfig(ctx, platform, function, "", "")
+	return e.Config, withMethod(err, platform, function)
 }
 
+// Search serves fresh cached results when it can and asks upstream otherwise.
 func (c *Client) Search(ctx context.Context, platform, keyword string, page, limit int) ([]SearchItem, error) {
-	if strings.EqualFold(strings.TrimSpace(platform), "qq") {
-		return c.searchQQ(ctx, keyword, page, limit)
+	if hit, ok := c.CachedSearch(platform, keyword, page, limit); ok && !hit.Stale {
+		return hit.Items, nil
 	}
+	return c.SearchFresh(ctx, platform, keyword, page, limit)
+}
 
-	cfg, err := c.GetMethodConfig(ctx, platform, "search")
-	if err != nil {
-		return nil, err
+func (c *Client) search(ctx context.Context, platform, keyword string, page, limit int) ([]SearchItem, error) {
+	if _, ok := c.Overrides.Config(platform, "search"); !ok && strings.EqualFold(strings.TrimSpace(platform), "qq") {
+		return c.searchQQ(ctx, keyword, page, limit)
 	}
+
 	vars := map[string]any{
 		"keyword": keyword,
 		"page":    page,
 		"limit":   limit,
 	}
+	var items []SearchItem
+	if err := c.runMethod(ctx, platform, "search", vars, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c *Client) runMethod(ctx context.Context, platform, function string, vars map[string]any, out any) error {
+	return withMethod(c.runMethodRaw(ctx, platform, function, vars, out), platform, function)
+}
+
+func (c *Client) runMethodRaw(ctx context.Context, platform, function string, vars map[string]any, out any) error {
+	cfg, err := c.GetMethodConfig(ctx, platform, function)
+	if err != nil {
+		return err
+	}
 
-	upstream, err := c.execMethod(ctx, cfg, vars)
+	upstream, err := c.execConfig(netroute.WithPlatform(ctx, platform), platform, cfg, vars)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if strings.TrimSpace(cfg.Transform) == "" {
-		return nil, errors.New("missing transform")
+		return errors.New("missing transform")
 	}
 	if c.JS == nil {
-		return nil, errors.New("jsbox runner not configured")
+		return errors.New("jsbox runner not configured")
 	}
 
 	transformed, err := c.JS.Transform(ctx, cfg.Transform, upstream)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	b, err := json.Marshal(transformed)
+	skipped, err := decodeOutput(function, transformed, out)
 	if err != nil {
-		return nil, err
+		return &Error{Reason: ReasonTransform, Message: "transform output doesn't fit the " + function + " schema", Err: err}
 	}
-	var items []SearchItem
-	if err := json.Unmarshal(b, &items); err != nil {
-		return nil, err
+	if len(skipped) > 0 {
+		c.warnf("%s/%s: skipped %d item(s) missing a required value, first at %s", platform, function, len(skipped), skipped[0])
 	}
-	return items, nil
+	return nil
 }
 
+// Parse resolves comma-separated ids to download URLs. Tracks whose cached
+// result is still valid are served from c.Parses at no cost; only the rest
+// are sent to TuneHub.
 func (c *Client) Parse(ctx context.Context, apiKey, platform, ids, quality string) (ParseData, error) {
-	apiKey = strings.TrimSpace(apiKey)
-	if apiKey == "" {
-		return ParseData{}, errors.New("missing api key")
+	if c.Parses == nil {
+		return c.parseFresh(ctx, apiKey, platform, ids, quality)
+	}
+	all := splitIDs(ids)
+	cached := map[string]ParseItem{}
+	var rest []string
+	for _, id := range all {
+		if it, ok := c.Parses.Lookup(platform, id, quality); ok {
+			cached[id] = it
+		} else {
+			rest = append(rest, id)
+		}
 	}
-	reqBody := ParseRequest{Platform: platform, IDs: ids, Quality: quality}
-	b, err := json.Marshal(reqBody)
-	if err != nil {
-		return ParseData{}, err
+
+	var pd ParseData
+	if len(rest) > 0 {
+		var err error
+		if pd, err = c.parseFresh(ctx, apiKey, platform, strings.Join(rest, ","), quality); err != nil {
+			return ParseData{}, err
+		}
+		for _, it := range pd.Data {
+			c.Parses.Store(platform, quality, it)
+		}
+	}
+	if len(cached) == 0 {
+		return pd, nil
+	}
+	if c.Meter != nil {
+		var hits []string
+		for _, id := range all {
+			if _, ok := cached[id]; ok {
+				hits = append(hits, id)
+			}
+		}
+		c.Meter.AfterParse(ctx, ParseRecord{
+			Time:      time.Now(),
+			Platform:  platform,
+			IDs:       hits,
+			Quality:   quality,
+			CacheHits: len(hits),
+			Succeeded: len(hits),
+			Local:     true,
+		})
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/v1/parse", bytes.NewReader(b))
-	if err != nil {
-		return ParseData{}, err
+	// Put the answer back in request order.
+	remote := pd.Data
+	pd.Data = make([]ParseItem, 0, len(all))
+	for _, id := range all {
+		if it, ok := cached[id]; ok {
+			pd.Data = append(pd.Data, it)
+			continue
+		}
+		for i, it := range remote {
+			if it.ID == id {
+				pd.Data = append(pd.Data, it)
+				remote = append(remote[:i], remote[i+1:]...)
+				break
+			}
+		}
+	}
+	pd.Data = append(pd.Data, remote...)
+	pd.Total += len(cached)
+	pd.SuccessCount += len(cached)
+	pd.CacheHitCount += len(cached)
+	return pd, nil
+}
+
+// ParseChunk caps the IDs sent in one Parse call.
+const ParseChunk = 50
+
+// ParseChunks parses ids ParseChunk at a time, so a long list isn't one huge
+// paid request and a failed call only loses its own chunk. fn gets each
+// chunk's ids with its result or error, in order.
+func (c *Client) ParseChunks(ctx context.Context, apiKey, platform string, ids []string, quality string, fn func(chunk []string, pd ParseData, err error)) {
+	for start := 0; start < len(ids); start += ParseChunk {
+		chunk := ids[start:min(start+ParseChunk, len(ids))]
+		pd, err := c.Parse(ctx, apiKey, platform, strings.Join(chunk, ","), quality)
+		fn(chunk, pd, err)
+	}
+}
+
+// parseFresh asks the platform's local parse config when there is one and
+// TuneHub otherwise.
+func (c *Client) parseFresh(ctx context.Context, apiKey, platform, ids, quality string) (ParseData, error) {
+	if _, ok := c.Overrides.Config(platform, "parse"); ok {
+		return c.parseLocal(ctx, platform, ids, quality)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", apiKey)
+	if c.Overrides.Custom(platform) {
+		return ParseData{}, &Error{Reason: ReasonConfig, Op: "parse", Platform: platform, Function: "parse",
+			Message: "no parse.json for this user-defined platform"}
+	}
+	return c.parseRemote(ctx, apiKey, platform, ids, quality)
+}
 
-	res, err := c.httpForHost(req.URL.Hostname()).Do(req)
+func (c *Client) parseRemote(ctx context.Context, apiKey, platform, ids, quality string) (ParseData, error) {
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return ParseData{}, &Error{Reason: ReasonAuth, Op: "parse", Message: "missing api key"}
+	}
+	if c.Meter != nil {
+		if err := c.Meter.BeforeParse(ctx, platform, splitIDs(ids)); err != nil {
+			return ParseData{}, err
+		}
+	}
+	reqBody := ParseRequest{Platform: platform, IDs: ids, Quality: quality}
+	b, err := json.Marshal(reqBody)
 	if err != nil {
 		return ParseData{}, err
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(io.LimitReader(res.Body, 2*1024*1024))
+	// Parse spends quota, so it isn't idempotent.
+	res, body, err := c.send(ctx, "parse", false, 2*1024*1024, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/v1/parse", bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", apiKey)
+		return req, nil
+	})
 	if err != nil {
 		return ParseData{}, err
 	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return ParseData{}, fmt.Errorf("tunehub parse http %d", res.StatusCode)
+		e := httpError("parse", res.StatusCode)
+		// Error bodies usually still carry the API message.
+		var resp APIResponse[json.RawMessage]
+		if json.Unmarshal(body, &resp) == nil {
+			e.Code, e.Message = resp.Code, resp.Message
+		}
+		return ParseData{}, e
 	}
 
 	var resp APIResponse[ParseData]
 	if err := json.Unmarshal(body, &resp); err != nil {
-		return ParseData{}, err
+		return ParseData{}, &Error{Reason: ReasonService, Op: "parse", Message: "bad response", Err: err}
 	}
 	if resp.Code != 0 {
-		return ParseData{}, fmt.Errorf("tunehub: %s", resp.Message)
+		return ParseData{}, apiError("parse", resp.Code, resp.Message)
+	}
+	for i := range resp.Data.Data {
+		if resp.Data.Data[i].Platform == "" {
+			resp.Data.Data[i].Platform = platform
+		}
+	}
+	if c.Meter != nil {
+		c.Meter.AfterParse(ctx, ParseRecord{
+			Time:      time.Now(),
+			Platform:  platform,
+			IDs:       splitIDs(ids),
+			Quality:   quality,
+			Cost:      resp.Data.Cost,
+			CacheHits: resp.Data.CacheHitCount,
+			Succeeded: resp.Data.SuccessCount,
+			Failed:    resp.Data.FailCount,
+		})
 	}
 	return resp.Data, nil
 }
 
-func (c *Client) execMethod(ctx context.Context, cfg MethodConfig, vars map[string]any) (any, error) {
-	if strings.ToLower(cfg.Type) != "http" {
-		return nil, fmt.Errorf("unsupported method type: %q", cfg.Type)
-	}
-	method := strings.ToUpper(strings.TrimSpace(cfg.Method))
-	if method != http.MethodGet && method != http.MethodPost {
-		return nil, fmt.Errorf("unsupported http method: %q", cfg.Method)
+func splitIDs(ids string) []string {
+	var out []string
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			out = append(out, id)
+		}
 	}
-	if strings.TrimSpace(cfg.URL) == "" {
-		return nil, errors.New("missing url")
+	return out
+}
+
+func (c *Client) execMethod(ctx context.Context, platform string, cfg MethodConfig, vars map[string]any) (any, error) {
+	if err := validateRequest(cfg, false); err != nil {
+		return nil, err
 	}
+	method := strings.ToUpper(strings.TrimSpace(cfg.Method))
 
 	paramsAny, err := template.RenderAny(cfg.Params, vars)
 	if err != nil {
@@ -191,79 +370,95 @@ func (c *Client) execMethod(ctx context.Context, cfg MethodConfig, vars map[stri
 	if err != nil {
 		return nil, err
 	}
-	rewriteNeteaseAPIHost(u)
 	q := u.Query()
 	for k, v := range params {
 		q.Set(k, fmt.Sprint(v))
 	}
 	u.RawQuery = q.Encode()
 
-	var bodyReader io.Reader
+	var reqBody []byte
 	if method == http.MethodPost && len(cfg.Body) > 0 {
 		bodyAny, err := template.RenderAny(cfg.Body, vars)
 		if err != nil {
 			return nil, err
 		}
-		bb, err := json.Marshal(bodyAny)
-		if err != nil {
+		if reqBody, err = encodeBody(cfg, bodyAny); err != nil {
 			return nil, err
 		}
-		bodyReader = bytes.NewReader(bb)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
-	if err != nil {
-		return nil, err
-	}
-	for k, v := range cfg.Headers {
-		req.Header.Set(k, v)
-	}
-	if method == http.MethodPost && req.Header.Get("Content-Type") == "" {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	if req.Header.Get("User-Agent") == "" {
-		req.Header.Set("User-Agent", "kotodama-kamataichi")
+	cookies := map[string]string{}
+	for k, v := range cfg.Cookies {
+		if cookies[k], err = template.RenderString(v, vars); err != nil {
+			return nil, err
+		}
 	}
-	res, err := c.httpForHost(req.URL.Hostname()).Do(req)
-	if err != nil {
-		return nil, err
+	var jar http.CookieJar
+	if cfg.CookieJar {
+		jar = c.jar(platform)
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(io.LimitReader(res.Body, 4*1024*1024))
+	limit := cfg.MaxBytes
+	if limit <= 0 {
+		limit = defaultMethodBytes
+	}
+	// Method configs only read from the platform, so they're safe to repeat.
+	res, body, err := c.send(ctx, "upstream", true, limit, func() (*http.Request, error) {
+		var bodyReader io.Reader
+		if reqBody != nil {
+			bodyReader = bytes.NewReader(reqBody)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		if method == http.MethodPost && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", bodyContentType(cfg))
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "kotodama-kamataichi")
+		}
+		if jar != nil {
+			for _, ck := range jar.Cookies(req.URL) {
+				if _, ok := cookies[ck.Name]; !ok {
+					req.AddCookie(ck)
+				}
+			}
+		}
+		for k, v := range cookies {
+			req.AddCookie(&http.Cookie{Name: k, Value: v})
+		}
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("upstream http %d", res.StatusCode)
+	if jar != nil {
+		jar.SetCookies(u, res.Cookies())
 	}
-
-	var decoded any
-	if err := json.Unmarshal(body, &decoded); err == nil {
-		return decoded, nil
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, upstreamError(res.StatusCode)
 	}
-	return string(body), nil
+	return decodeResponse(cfg, res, body, limit)
 }
 
-func (c *Client) getJSON(ctx context.Context, path string, out any) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
-	if err != nil {
-		return err
-	}
-	res, err := c.httpForHost(req.URL.Hostname()).Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(io.LimitReader(res.Body, 2*1024*1024))
+func (c *Client) getJSON(ctx context.Context, op, path string, out any) error {
+	res, body, err := c.send(ctx, op, true, 2*1024*1024, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	})
 	if err != nil {
 		return err
 	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return fmt.Errorf("tunehub http %d", res.StatusCode)
+		return httpError(op, res.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return &Error{Reason: ReasonService, Op: op, Message: "bad response", Err: err}
 	}
-	return json.Unmarshal(body, out)
+	return nil
 }
 
 func (c *Client) http() *http.Client {
@@ -273,32 +468,10 @@ func (c *Client) http() *http.Client {
 	return http.DefaultClient
 }
 
-func (c *Client) httpForHost(host string) *http.Client {
-	host = strings.TrimSpace(strings.ToLower(host))
-	if host == "music.163.com" || host == "interface.music.163.com" || host == "interface3.music.163.com" {
-		if c.HTTPv4 != nil {
-			return c.HTTPv4
-		}
+// route applies c.Routes to req and returns the client to send it with.
+func (c *Client) route(req *http.Request) *http.Client {
+	if c.Routes != nil {
+		return c.Routes.Route(req)
 	}
 	return c.http()
 }
-
-func rewriteNeteaseAPIHost(u *url.URL) {
-	if u == nil {
-		return
-	}
-	host := strings.TrimSpace(strings.ToLower(u.Hostname()))
-	if host != "music.163.com" {
-		return
-	}
-	if !strings.HasPrefix(u.Path, "/api/") {
-		return
-	}
-
-	port := u.Port()
-	newHost := "interface.music.163.com"
-	if port != "" {
-		newHost = net.JoinHostPort(newHost, port)
-	}
-	u.Host = newHost
-}
@@ -0,0 +1,72 @@
+package tunehub
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+const (
+	KindPlaylist = "playlist"
+	KindAlbum    = "album"
+	KindArtist   = "artist"
+)
+
+// Collection is an ordered track list with whatever metadata the platform
+// reports about its source.
+type Collection struct {
+	Kind        string       `json:"kind"`
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Creator     string       `json:"creator"`
+	Cover       string       `json:"cover"`
+	Description string       `json:"description"`
+	Total       int          `json:"total"`
+	Tracks      []SearchItem `json:"tracks"`
+}
+
+func (c *Client) Playlist(ctx context.Context, platform, id string) (Collection, error) {
+	return c.collection(ctx, platform, KindPlaylist, "playlist", id)
+}
+
+func (c *Client) Album(ctx context.Context, platform, id string) (Collection, error) {
+	return c.collection(ctx, platform, KindAlbum, "album", id)
+}
+
+func (c *Client) ArtistTopTracks(ctx context.Context, platform, id string) (Collection, error) {
+	return c.collection(ctx, platform, KindArtist, "artist", id)
+}
+
+// OpenCollection dispatches on kind (KindPlaylist, KindAlbum or KindArtist).
+func (c *Client) OpenCollection(ctx context.Context, platform, kind, id string) (Collection, error) {
+	switch kind {
+	case KindPlaylist:
+		return c.Playlist(ctx, platform, id)
+	case KindAlbum:
+		return c.Album(ctx, platform, id)
+	case KindArtist:
+		return c.ArtistTopTracks(ctx, platform, id)
+	default:
+		return Collection{}, errors.New("unknown collection kind: " + kind)
+	}
+}
+
+func (c *Client) collection(ctx context.Context, platform, kind, function, id string) (Collection, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return Collection{}, errors.New("missing " + kind + " id")
+	}
+	// Transforms may return just the track array; the schema allows it.
+	var out Collection
+	if err := c.runMethod(ctx, platform, function, map[string]any{"id": id}, &out); err != nil {
+		return Collection{}, err
+	}
+	out.Kind = kind
+	if out.ID == "" {
+		out.ID = id
+	}
+	if out.Total == 0 {
+		out.Total = len(out.Tracks)
+	}
+	return out, nil
+}
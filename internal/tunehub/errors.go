@@ -0,0 +1,216 @@
+package tunehub
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"kotodama-kamataichi/internal/jsbox"
+)
+
+// Reason classifies an Error by what the user can do about it.
+type Reason string
+
+const (
+	ReasonNetwork   Reason = "network"
+	ReasonAuth      Reason = "auth"
+	ReasonQuota     Reason = "quota"
+	ReasonNotFound  Reason = "not_found"
+	ReasonService   Reason = "service"  // TuneHub itself failed
+	ReasonUpstream  Reason = "upstream" // the music platform failed
+	ReasonTransform Reason = "transform"
+	ReasonConfig    Reason = "config" // the method config can't be used
+	ReasonInvalid   Reason = "invalid"
+)
+
+// Error is returned for every failure talking to TuneHub or to a platform
+// through a method config.
+type Error struct {
+	Reason   Reason
+	Op       string
+	Platform string
+	Function string
+	// Status is the HTTP status, 0 when no response arrived.
+	Status int
+	// Code is TuneHub's API code, 0 when the API didn't report one.
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("tunehub")
+	if e.Op != "" {
+		b.WriteString(" " + e.Op)
+	}
+	if e.Platform != "" || e.Function != "" {
+		b.WriteString(" " + e.Platform + "/" + e.Function)
+	}
+	if e.Status != 0 {
+		fmt.Fprintf(&b, ": http %d", e.Status)
+	}
+	if e.Code != 0 {
+		fmt.Fprintf(&b, ": code %d", e.Code)
+	}
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrNoMethod is wrapped by the error for a platform that has no config for
+// the function at all, as opposed to one that failed.
+var ErrNoMethod = errors.New("no such method")
+
+// Retryable reports whether the same call may succeed later unchanged.
+func (e *Error) Retryable() bool {
+	if errors.Is(e.Err, context.Canceled) {
+		return false
+	}
+	switch e.Reason {
+	case ReasonNetwork:
+		return true
+	case ReasonService, ReasonUpstream:
+		return e.Status == 0 || e.Status == http.StatusTooManyRequests || e.Status >= 500
+	case ReasonQuota:
+		return e.Status == http.StatusTooManyRequests
+	case ReasonTransform:
+		var je *jsbox.Error
+		return errors.As(e.Err, &je) && je.Retryable()
+	}
+	return false
+}
+
+func (e *Error) Hint() string {
+	switch e.Reason {
+	case ReasonNetwork:
+		var ce *CircuitOpenError
+		if errors.As(e.Err, &ce) {
+			return ce.Host + " failed several times in a row; wait a moment before trying again"
+		}
+		return "check your network connection or proxy settings and try again"
+	case ReasonAuth:
+		return "check the TuneHub API key (TUNEHUB_API_KEY or -key)"
+	case ReasonQuota:
+		if e.Status == http.StatusTooManyRequests {
+			return "too many requests; wait a moment and try again"
+		}
+		return "the API key is out of quota or credits; top it up or wait for the reset"
+	case ReasonNotFound:
+		return "the song, album or playlist wasn't found on " + cmp.Or(e.Platform, "the platform")
+	case ReasonService:
+		return "TuneHub is having trouble; try again later"
+	case ReasonUpstream:
+		return cmp.Or(e.Platform, "the platform") + " rejected the request; try again later or pick another platform"
+	case ReasonTransform:
+		return "the platform's response couldn't be read; TuneHub's method config may be out of date"
+	case ReasonConfig:
+		var pe *PinError
+		if errors.As(e.Err, &pe) {
+			return pe.Hint()
+		}
+		return "TuneHub's method config for " + e.Platform + "/" + e.Function + " is unusable; try again later"
+	}
+	return ""
+}
+
+// statusReason maps a TuneHub HTTP status to a Reason.
+func statusReason(status int) Reason {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ReasonAuth
+	case status == http.StatusPaymentRequired || status == http.StatusTooManyRequests:
+		return ReasonQuota
+	case status == http.StatusNotFound:
+		return ReasonNotFound
+	case status >= 500:
+		return ReasonService
+	}
+	return ReasonInvalid
+}
+
+// codeReason maps a non-zero API code to a Reason. TuneHub doesn't document
+// its codes, so only those mirroring an HTTP status are trusted; anything
+// else is guessed from the message. Quota wording is checked first, as
+// quota messages often mention the key too ("rate limit exceeded for key").
+func codeReason(code int, msg string) Reason {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusPaymentRequired,
+		http.StatusTooManyRequests, http.StatusNotFound:
+		return statusReason(code)
+	}
+	if code >= 500 && code < 600 {
+		return ReasonService
+	}
+	m := strings.ToLower(msg)
+	has := func(subs ...string) bool {
+		for _, s := range subs {
+			if strings.Contains(m, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("quota", "credit", "balance", "rate limit", "too many", "limit exceeded", "余额", "积分", "频繁"):
+		return ReasonQuota
+	case has("invalid key", "api key", "apikey", "api_key", "unauthor", "forbidden", "密钥"):
+		return ReasonAuth
+	case has("not found", "不存在"):
+		return ReasonNotFound
+	}
+	return ReasonService
+}
+
+func httpError(op string, status int) *Error {
+	return &Error{Reason: statusReason(status), Op: op, Status: status}
+}
+
+func upstreamError(status int) *Error {
+	reason := ReasonUpstream
+	if status == http.StatusNotFound {
+		reason = ReasonNotFound
+	}
+	return &Error{Reason: reason, Op: "upstream", Status: status}
+}
+
+func apiError(op string, code int, msg string) *Error {
+	return &Error{Reason: codeReason(code, msg), Op: op, Code: code, Message: msg}
+}
+
+func netError(op string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &Error{Reason: ReasonNetwork, Op: op, Err: err}
+}
+
+// withMethod tags err with the platform/function it came from, classifying
+// errors that aren't an *Error yet.
+func withMethod(err error, platform, function string) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var te *Error
+	if !errors.As(err, &te) {
+		te = &Error{Reason: ReasonConfig, Err: err}
+		var je *jsbox.Error
+		if errors.As(err, &je) {
+			te.Reason = ReasonTransform
+		}
+		err = te
+	}
+	if te.Platform == "" {
+		te.Platform, te.Function = platform, function
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+package tunehub
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"kotodama-kamataichi/internal/netroute"
+)
+
+const KindSong = "song"
+
+// Link is what a pasted share URL points at.
+type Link struct {
+	Platform string `json:"platform"`
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+}
+
+const maxLinkRedirects = 10
+
+var (
+	urlInText  = regexp.MustCompile(`(?i)https?://[^\s"'<>()（）]+`)
+	bareDomain = regexp.MustCompile(`(?i)[a-z0-9-]+(\.[a-z0-9-]+)+(/[^\s"'<>()（）]*)?`)
+)
+
+// LooksLikeLink reports whether s should be resolved rather than searched.
+// Share texts with a URL embedded count too.
+func LooksLikeLink(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.Contains(s, "://") || ParseLink(s) != (Link{})
+}
+
+// ResolveLink turns a share URL into a Link. URLs that aren't recognized
+// directly are fetched and their redirects followed (short links); the
+// first hop that parses wins.
+func (c *Client) ResolveLink(ctx context.Context, raw string) (Link, error) {
+	u, err := extractURL(raw)
+	if err != nil {
+		return Link{}, err
+	}
+	if l := parseLinkURL(u); l != (Link{}) {
+		return l, nil
+	}
+
+	if p := hostPlatform(u.Hostname()); p != "" {
+		ctx = netroute.WithPlatform(ctx, p)
+	}
+	var found Link
+	hc := http.Client{Transport: routedTransport{c}, Timeout: c.http().Timeout}
+	if c.LinkHTTP != nil {
+		hc = *c.LinkHTTP
+	}
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if l := parseLinkURL(req.URL); l != (Link{}) {
+			found = l
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= maxLinkRedirects {
+			return errors.New("too many redirects")
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Link{}, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 kotodama-kamataichi")
+	res, err := hc.Do(req)
+	if err != nil {
+		return Link{}, err
+	}
+	res.Body.Close()
+	if found != (Link{}) {
+		return found, nil
+	}
+	if l := parseLinkURL(res.Request.URL); l != (Link{}) {
+		return l, nil
+	}
+	return Link{}, fmt.Errorf("unrecognized link: %s", u.Redacted())
+}
+
+// routedTransport sends every hop of a redirect chain through c.route, so
+// route rules and platform proxies apply to the short-link host and to
+// wherever it redirects.
+type routedTransport struct{ c *Client }
+
+func (t routedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Routing rewrites the request; a RoundTripper must not touch the
+	// caller's.
+	req = req.Clone(req.Context())
+	tr := t.c.route(req).Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	return tr.RoundTrip(req)
+}
+
+// ParseLink recognizes a share URL without touching the network. It returns
+// the zero Link when s isn't a known song, album or playlist URL.
+func ParseLink(s string) Link {
+	u, err := extractURL(s)
+	if err != nil {
+		return Link{}
+	}
+	return parseLinkURL(u)
+}
+
+func extractURL(s string) (*url.URL, error) {
+	s = strings.TrimSpace(s)
+	m := urlInText.FindString(s)
+	if m == "" {
+		m = bareDomain.FindString(s)
+	}
+	if m == "" {
+		return nil, errors.New("no link found")
+	}
+	if !strings.Contains(m, "://") {
+		m = "https://" + m
+	}
+	u, err := url.Parse(m)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported link scheme: %q", u.Scheme)
+	}
+	return u, nil
+}
+
+func parseLinkURL(u *url.URL) Link {
+	if u == nil {
+		return Link{}
+	}
+	switch hostPlatform(u.Hostname()) {
+	case "netease":
+		return parseNeteaseLink(u)
+	case "qq":
+		return parseQQLink(u)
+	case "kuwo":
+		return parseKuwoLink(u)
+	}
+	return Link{}
+}
+
+// hostPlatform names the platform a share-link host belongs to, short-link
+// domains included; empty when unknown.
+func hostPlatform(host string) string {
+	host = strings.ToLower(host)
+	under := func(domains ...string) bool {
+		for _, d := range domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case under("163.com", "163cn.tv"):
+		return "netease"
+	case under("qq.com", "url.cn"):
+		return "qq"
+	case under("kuwo.cn"):
+		return "kuwo"
+	}
+	return ""
+}
+
+// The web player keeps the route in the fragment: music.163.com/#/song?id=1.
+func parseNeteaseLink(u *url.URL) Link {
+	route, q := u.Path, u.Query()
+	if strings.HasPrefix(u.Fragment, "/") {
+		fu, err := url.Parse(u.Fragment)
+		if err == nil {
+			route, q = fu.Path, fu.Query()
+		}
+	}
+	segs := pathSegments(route)
+	if len(segs) == 0 {
+		return Link{}
+	}
+	var kind string
+	switch segs[len(segs)-1] {
+	case "song":
+		kind = KindSong
+	case "album":
+		kind = KindAlbum
+	case "playlist":
+		kind = KindPlaylist
+	case "artist":
+		kind = KindArtist
+	}
+	id := q.Get("id")
+	// music.163.com/song/123/?userid=...
+	if kind == "" && len(segs) >= 2 {
+		switch segs[len(segs)-2] {
+		case "song":
+			kind, id = KindSong, segs[len(segs)-1]
+		case "album":
+			kind, id = KindAlbum, segs[len(segs)-1]
+		case "playlist":
+			kind, id = KindPlaylist, segs[len(segs)-1]
+		}
+	}
+	if kind == "" || !isDigits(id) {
+		return Link{}
+	}
+	return Link{Platform: "netease", Kind: kind, ID: id}
+}
+
+func parseQQLink(u *url.URL) Link {
+	q := u.Query()
+	segs := pathSegments(u.Path)
+	last := ""
+	if len(segs) > 0 {
+		last = strings.TrimSuffix(segs[len(segs)-1], ".html")
+	}
+	link := func(kind, id string) Link {
+		if id == "" {
+			return Link{}
+		}
+		return Link{Platform: "qq", Kind: kind, ID: id}
+	}
+
+	// y.qq.com/n/ryqq/songDetail/<mid>, y.qq.com/n/yqq/song/<mid>.html
+	if len(segs) >= 2 {
+		switch segs[len(segs)-2] {
+		case "songDetail", "song":
+			return link(KindSong, last)
+		case "albumDetail", "album":
+			return link(KindAlbum, last)
+		case "playlist", "playsquare":
+			return link(KindPlaylist, last)
+		case "singer":
+			return link(KindArtist, last)
+		}
+	}
+	// Mobile share pages: i.y.qq.com/v8/playsong.html?songmid=...
+	switch {
+	case q.Get("songmid") != "":
+		return link(KindSong, q.Get("songmid"))
+	case q.Get("songid") != "":
+		return link(KindSong, q.Get("songid"))
+	case q.Get("albummid") != "":
+		return link(KindAlbum, q.Get("albummid"))
+	case q.Get("albumId") != "":
+		return link(KindAlbum, q.Get("albumId"))
+	case last == "taoge" || last == "playlist":
+		return link(KindPlaylist, cmp.Or(q.Get("id"), q.Get("disstid")))
+	}
+	return Link{}
+}
+
+func parseKuwoLink(u *url.URL) Link {
+	segs := pathSegments(u.Path)
+	if len(segs) >= 2 && isDigits(segs[len(segs)-1]) {
+		id := segs[len(segs)-1]
+		switch segs[len(segs)-2] {
+		case "play_detail":
+			return Link{Platform: "kuwo", Kind: KindSong, ID: id}
+		case "album_detail":
+			return Link{Platform: "kuwo", Kind: KindAlbum, ID: id}
+		case "playlist_detail":
+			return Link{Platform: "kuwo", Kind: KindPlaylist, ID: id}
+		case "singer_detail":
+			return Link{Platform: "kuwo", Kind: KindArtist, ID: id}
+		}
+	}
+	// m.kuwo.cn/h5app/single/?rid=MUSIC_123
+	if rid := strings.TrimPrefix(u.Query().Get("rid"), "MUSIC_"); isDigits(rid) {
+		return Link{Platform: "kuwo", Kind: KindSong, ID: rid}
+	}
+	return Link{}
+}
+
+func pathSegments(p string) []string {
+	var out []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package tunehub
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+func (c *Client) Lyrics(ctx context.Context, platform, id string) (LyricSet, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return LyricSet{}, errors.New("missing song id")
+	}
+	var out LyricSet
+	if err := c.runMethod(ctx, platform, "lyric", map[string]any{"id": id}, &out); err != nil {
+		return LyricSet{}, err
+	}
+	return out, nil
+}
+
+// AttachLyrics fills in the translated and romanized variants of item from
+// the platform's lyric method. The original lyric is only replaced when
+// parse didn't return one. A platform without a lyric method is not an
+// error; the item is left as parse returned it.
+func (c *Client) AttachLyrics(ctx context.Context, platform string, item *ParseItem) error {
+	if item == nil {
+		return errors.New("nil parse item")
+	}
+	if c.Parses != nil && c.Parses.cachedLyrics(platform, item) {
+		return nil
+	}
+	set, err := c.Lyrics(ctx, platform, item.ID)
+	if errors.Is(err, ErrNoMethod) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.Parses != nil {
+		defer func() { c.Parses.storeLyrics(platform, *item) }()
+	}
+	if strings.TrimSpace(item.Lyrics) == "" {
+		item.Lyrics = set.Lyric
+	}
+	if strings.TrimSpace(set.Trans) != "" {
+		item.TransLyrics = set.Trans
+	}
+	if strings.TrimSpace(set.Roma) != "" {
+		item.RomaLyrics = set.Roma
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package tunehub
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+
+	"kotodama-kamataichi/internal/jsbox"
+)
+
+// defaultMethodBytes bounds an upstream response when the config sets no
+// MaxBytes.
+const defaultMethodBytes = 4 * 1024 * 1024
+
+// checkMethod rejects settings execMethod doesn't understand before anything
+// is sent.
+func checkMethod(cfg MethodConfig) error {
+	switch strings.ToLower(cfg.BodyEncoding) {
+	case "", "json", "form":
+	default:
+		return fmt.Errorf("unsupported body encoding: %q", cfg.BodyEncoding)
+	}
+	switch strings.ToLower(cfg.Response) {
+	case "", "json", "jsonp", "text", "xml":
+	default:
+		return fmt.Errorf("unsupported response format: %q", cfg.Response)
+	}
+	if cs := strings.TrimSpace(cfg.Charset); cs != "" {
+		if _, err := htmlindex.Get(cs); err != nil {
+			return fmt.Errorf("unsupported charset: %q", cfg.Charset)
+		}
+	}
+	if cfg.MaxBytes < 0 {
+		return fmt.Errorf("maxBytes must not be negative")
+	}
+	return nil
+}
+
+func bodyContentType(cfg MethodConfig) string {
+	if strings.ToLower(cfg.BodyEncoding) == "form" {
+		return "application/x-www-form-urlencoded"
+	}
+	return "application/json"
+}
+
+// encodeBody renders a method body in the config's encoding.
+func encodeBody(cfg MethodConfig, body any) ([]byte, error) {
+	if strings.ToLower(cfg.BodyEncoding) != "form" {
+		return json.Marshal(body)
+	}
+	m, ok := body.(map[string]any)
+	if !ok {
+		return nil, errors.New("form body must be an object")
+	}
+	form := url.Values{}
+	for k, v := range m {
+		s, err := formValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("form field %s: %w", k, err)
+		}
+		form.Set(k, s)
+	}
+	return []byte(form.Encode()), nil
+}
+
+// formValue sends strings as they are and anything else, including nested
+// objects some APIs expect in a single field, as JSON.
+func formValue(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// jar returns the cookie jar method configs for platform share.
+func (c *Client) jar(platform string) http.CookieJar {
+	c.jarsMu.Lock()
+	defer c.jarsMu.Unlock()
+	if c.jars == nil {
+		c.jars = map[string]http.CookieJar{}
+	}
+	j, ok := c.jars[platform]
+	if !ok {
+		j, _ = cookiejar.New(nil)
+		c.jars[platform] = j
+	}
+	return j
+}
+
+// decodeResponse turns an upstream body into the value the transform sees.
+func decodeResponse(cfg MethodConfig, res *http.Response, body []byte, limit int64) (any, error) {
+	body, err := decompress(res, body, limit)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = decodeCharset(cfg.Charset, res.Header.Get("Content-Type"), body); err != nil {
+		return nil, err
+	}
+
+	// Numbers stay json.Number: IDs past 2^53 don't survive a float64.
+	var v any
+	switch strings.ToLower(cfg.Response) {
+	case "":
+		// Without a declared format, anything that isn't JSON is text.
+		if jsbox.DecodeNumbers(body, &v) == nil {
+			return v, nil
+		}
+		return string(body), nil
+	case "text":
+		return string(body), nil
+	case "jsonp":
+		body = stripJSONP(body)
+		fallthrough
+	case "json":
+		err = jsbox.DecodeNumbers(body, &v)
+	case "xml":
+		v, err = decodeXML(body)
+	}
+	if err != nil {
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Message: "bad " + strings.ToLower(cfg.Response) + " response", Err: err}
+	}
+	return v, nil
+}
+
+// decompress undoes a Content-Encoding net/http left in place, which it does
+// whenever the request set its own Accept-Encoding.
+func decompress(res *http.Response, body []byte, limit int64) ([]byte, error) {
+	if res.Uncompressed {
+		return body, nil
+	}
+	var r io.Reader
+	var err error
+	switch enc := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send a
+		// raw stream.
+		if r, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Message: "unsupported content encoding " + enc}
+	}
+	if err == nil {
+		body, err = io.ReadAll(io.LimitReader(r, limit))
+	}
+	if err != nil {
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Message: "bad compressed response", Err: err}
+	}
+	return body, nil
+}
+
+// decodeCharset converts body to UTF-8 from charset, or from the charset the
+// Content-Type names when the config sets none.
+func decodeCharset(charset, contentType string, body []byte) ([]byte, error) {
+	cs := strings.TrimSpace(charset)
+	if cs == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			cs = params["charset"]
+		}
+	}
+	if cs == "" || strings.EqualFold(cs, "utf-8") || strings.EqualFold(cs, "utf8") {
+		return body, nil
+	}
+	enc, err := htmlindex.Get(cs)
+	if err != nil {
+		// A charset the server made up is most likely UTF-8 anyway.
+		if charset == "" {
+			return body, nil
+		}
+		return nil, fmt.Errorf("unsupported charset: %q", charset)
+	}
+	out, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Message: "bad " + cs + " text", Err: err}
+	}
+	return out, nil
+}
+
+// stripJSONP returns the argument of a "callback(...)" wrapper, or body
+// unchanged when there is none.
+func stripJSONP(body []byte) []byte {
+	b := bytes.TrimSpace(body)
+	b = bytes.TrimSuffix(b, []byte(";"))
+	open := bytes.IndexByte(b, '(')
+	if open < 0 || !bytes.HasSuffix(b, []byte(")")) {
+		return body
+	}
+	// Only an identifier (or a commented one, "/**/cb(") may come first.
+	name := bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(b[:open]), []byte("/**/")))
+	for _, r := range string(name) {
+		if !(r == '_' || r == '$' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return body
+		}
+	}
+	return b[open+1 : len(b)-1]
+}
+
+// decodeXML turns a document into the JSON-like value transforms work with:
+// an element becomes an object of its attributes ("@name"), children (an
+// array when repeated) and text ("#text"), or just its text when it has
+// nothing else. The root is keyed by its name.
+func decodeXML(body []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	// Platform XML is often GBK-declared but already converted above.
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			v, err := xmlElement(d, se)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{se.Name.Local: v}, nil
+		}
+	}
+}
+
+func xmlElement(d *xml.Decoder, se xml.StartElement) (any, error) {
+	node := map[string]any{}
+	for _, a := range se.Attr {
+		node["@"+a.Name.Local] = a.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := xmlElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			switch prev := node[t.Name.Local].(type) {
+			case nil:
+				node[t.Name.Local] = v
+			case []any:
+				node[t.Name.Local] = append(prev, v)
+			default:
+				node[t.Name.Local] = []any{prev, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(node) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				node["#text"] = s
+			}
+			return node, nil
+		}
+	}
+}
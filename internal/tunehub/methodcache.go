@@ -0,0 +1,178 @@
+package tunehub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultMethodTTL = 6 * time.Hour
+
+// MethodCache keeps method configs in memory and, when Dir is set, on disk.
+// Entries older than TTL are revalidated with ETag/If-Modified-Since; if that
+// fails the last good config is served and the client warns about it.
+type MethodCache struct {
+	Dir string
+	TTL time.Duration
+
+	mu  sync.Mutex
+	mem map[string]*methodEntry
+}
+
+type methodEntry struct {
+	Config       MethodConfig `json:"config"`
+	ETag         string       `json:"etag,omitempty"`
+	LastModified string       `json:"lastModified,omitempty"`
+	FetchedAt    time.Time    `json:"fetchedAt"`
+}
+
+func NewMethodCache(dir string, ttl time.Duration) *MethodCache {
+	return &MethodCache{Dir: dir, TTL: ttl, mem: map[string]*methodEntry{}}
+}
+
+func (mc *MethodCache) lookup(platform, function string) *methodEntry {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	key := platform + "/" + function
+	if e, ok := mc.mem[key]; ok {
+		return e
+	}
+	if mc.Dir == "" {
+		return nil
+	}
+	b, err := os.ReadFile(mc.path(platform, function))
+	if err != nil {
+		return nil
+	}
+	var e methodEntry
+	if json.Unmarshal(b, &e) != nil {
+		return nil
+	}
+	mc.mem[key] = &e
+	return &e
+}
+
+func (mc *MethodCache) store(platform, function string, e methodEntry) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.mem[platform+"/"+function] = &e
+	if mc.Dir == "" {
+		return
+	}
+	// The disk copy is only an optimization; a failed write costs a refetch.
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return
+	}
+	p := mc.path(platform, function)
+	if os.MkdirAll(filepath.Dir(p), 0o755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".method-*")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(b)
+	if cerr := tmp.Close(); werr != nil || cerr != nil || os.Rename(tmp.Name(), p) != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+func (mc *MethodCache) path(platform, function string) string {
+	return filepath.Join(mc.Dir, "methods", url.PathEscape(platform), url.PathEscape(function)+".json")
+}
+
+func (mc *MethodCache) ttl() time.Duration {
+	if mc.TTL > 0 {
+		return mc.TTL
+	}
+	return DefaultMethodTTL
+}
+
+func (c *Client) cachedMethodConfig(ctx context.Context, platform, function string) (MethodConfig, error) {
+	mc := c.Methods
+	cached := mc.lookup(platform, function)
+	if cached != nil && time.Since(cached.FetchedAt) < mc.ttl() {
+		return cached.Config, nil
+	}
+
+	var prev methodEntry
+	if cached != nil {
+		prev = *cached
+	}
+	e, notModified, err := c.fetchMethodConfig(ctx, platform, function, prev.ETag, prev.LastModified)
+	switch {
+	case err == nil && notModified && cached != nil:
+		prev.FetchedAt = time.Now()
+		mc.store(platform, function, prev)
+		return prev.Config, nil
+	case err == nil && !notModified:
+		mc.store(platform, function, e)
+		return e.Config, nil
+	case err == nil:
+		err = &Error{Reason: ReasonService, Op: "method config", Message: "unexpected 304"}
+	}
+	err = withMethod(err, platform, function)
+	if cached == nil || ctx.Err() != nil {
+		return MethodConfig{}, err
+	}
+	c.warnf("using cached %s/%s method config from %s: %v", platform, function, cached.FetchedAt.Local().Format("2006-01-02 15:04"), err)
+	return cached.Config, nil
+}
+
+func (c *Client) fetchMethodConfig(ctx context.Context, platform, function, etag, lastModified string) (methodEntry, bool, error) {
+	res, body, err := c.send(ctx, "method config", true, 2*1024*1024, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/v1/methods/"+url.PathEscape(platform)+"/"+url.PathEscape(function), nil)
+		if err != nil {
+			return nil, err
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return methodEntry{}, false, err
+	}
+	if res.StatusCode == http.StatusNotModified {
+		return methodEntry{}, true, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		e := httpError("method config", res.StatusCode)
+		if res.StatusCode == http.StatusNotFound {
+			// No such platform/function rather than a missing song.
+			e.Reason = ReasonConfig
+			e.Err = ErrNoMethod
+		}
+		return methodEntry{}, false, e
+	}
+	var resp APIResponse[MethodConfig]
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return methodEntry{}, false, &Error{Reason: ReasonService, Op: "method config", Message: "bad response", Err: err}
+	}
+	if resp.Code != 0 {
+		return methodEntry{}, false, apiError("method config", resp.Code, resp.Message)
+	}
+	return methodEntry{
+		Config:       resp.Data,
+		ETag:         strings.TrimSpace(res.Header.Get("ETag")),
+		LastModified: strings.TrimSpace(res.Header.Get("Last-Modified")),
+		FetchedAt:    time.Now(),
+	}, false, nil
+}
+
+func (c *Client) warnf(format string, args ...any) {
+	if c.Warn != nil {
+		c.Warn(fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,173 @@
+package tunehub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffMethods describes what changed from old to cfg, one line per changed
+// field ("~ url: a -> b", "+ params.q: …", "- headers.Referer: …") followed
+// by a line diff of each changed transform. It is empty when they are equal.
+func DiffMethods(old, cfg MethodConfig) []string {
+	a, b := map[string]string{}, map[string]string{}
+	at, bt := map[string]string{}, map[string]string{}
+	flattenMethod("", old, a, at)
+	flattenMethod("", cfg, b, bt)
+
+	var out []string
+	for _, k := range unionKeys(a, b) {
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inA:
+			out = append(out, "+ "+k+": "+vb)
+		case !inB:
+			out = append(out, "- "+k+": "+va)
+		case va != vb:
+			out = append(out, "~ "+k+": "+va+" -> "+vb)
+		}
+	}
+	for _, k := range unionKeys(at, bt) {
+		if at[k] == bt[k] {
+			continue
+		}
+		out = append(out, "~ "+k+":")
+		for _, l := range trimContext(diffLines(splitLines(at[k]), splitLines(bt[k])), 2) {
+			out = append(out, "    "+l)
+		}
+	}
+	return out
+}
+
+// flattenMethod lists every field of cfg that a config can set, keyed by
+// its JSON path; transforms go to code instead.
+func flattenMethod(prefix string, cfg MethodConfig, fields, code map[string]string) {
+	set := func(k, v string) {
+		if v != "" {
+			fields[prefix+k] = v
+		}
+	}
+	set("type", cfg.Type)
+	set("method", cfg.Method)
+	set("url", cfg.URL)
+	set("bodyEncoding", cfg.BodyEncoding)
+	set("response", cfg.Response)
+	set("charset", cfg.Charset)
+	if cfg.CookieJar {
+		set("cookieJar", "true")
+	}
+	if cfg.MaxBytes != 0 {
+		set("maxBytes", fmt.Sprint(cfg.MaxBytes))
+	}
+	for k, v := range cfg.Params {
+		set("params."+k, jsonText(v))
+	}
+	for k, v := range cfg.Body {
+		set("body."+k, jsonText(v))
+	}
+	for k, v := range cfg.Headers {
+		set("headers."+k, v)
+	}
+	for k, v := range cfg.Cookies {
+		set("cookies."+k, v)
+	}
+	if strings.TrimSpace(cfg.Transform) != "" {
+		code[prefix+"transform"] = cfg.Transform
+	}
+	names, err := stepNames(cfg.Steps, nil)
+	for i, step := range cfg.Steps {
+		name := fmt.Sprint(i + 1)
+		if err == nil {
+			name = names[i]
+		}
+		flattenMethod(prefix+"steps."+name+".", step.MethodConfig, fields, code)
+	}
+}
+
+func jsonText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func unionKeys(a, b map[string]string) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(s, "\n"), "\n")
+}
+
+// diffLines is a plain LCS diff; transforms are short enough for it.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+// trimContext keeps n unchanged lines around each change and marks the gaps.
+func trimContext(lines []string, n int) []string {
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if strings.HasPrefix(l, "  ") {
+			continue
+		}
+		for j := max(i-n, 0); j <= min(i+n, len(lines)-1); j++ {
+			keep[j] = true
+		}
+	}
+	var out []string
+	for i, l := range lines {
+		switch {
+		case keep[i]:
+			out = append(out, l)
+		case i == 0 || keep[i-1]:
+			out = append(out, "  …")
+		}
+	}
+	return out
+}
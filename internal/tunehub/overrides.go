@@ -0,0 +1,223 @@
+package tunehub
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"kotodama-kamataichi/internal/jsbox"
+)
+
+// Platforms are the platforms TuneHub serves.
+var Platforms = []string{"netease", "qq", "kuwo"}
+
+// Overrides are method configs kept on disk as <Dir>/<platform>/<function>.json.
+// They win over TuneHub's configs, and a platform directory TuneHub doesn't
+// serve defines a new platform; its "parse" config stands in for TuneHub's
+// parse API.
+type Overrides struct {
+	Dir string
+	// Errs lists the files that were skipped and why.
+	Errs []error
+
+	configs map[string]map[string]MethodConfig
+	custom  map[string]bool
+}
+
+// LoadOverrides reads every config under dir. A missing dir yields no
+// overrides; unusable files are left out and reported in Errs.
+func LoadOverrides(dir string) *Overrides {
+	o := &Overrides{Dir: dir, configs: map[string]map[string]MethodConfig{}, custom: map[string]bool{}}
+	if dir == "" {
+		return o
+	}
+	platforms, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			o.Errs = append(o.Errs, err)
+		}
+		return o
+	}
+	for _, pe := range platforms {
+		if !pe.IsDir() {
+			continue
+		}
+		platform := strings.ToLower(pe.Name())
+		if !slices.Contains(Platforms, platform) {
+			o.custom[platform] = true
+		}
+		files, err := os.ReadDir(filepath.Join(dir, pe.Name()))
+		if err != nil {
+			o.Errs = append(o.Errs, err)
+			continue
+		}
+		for _, fe := range files {
+			function, ok := strings.CutSuffix(fe.Name(), ".json")
+			if fe.IsDir() || !ok {
+				continue
+			}
+			path := filepath.Join(dir, pe.Name(), fe.Name())
+			cfg, err := readOverride(path)
+			if err != nil {
+				o.Errs = append(o.Errs, fmt.Errorf("%s: %w", path, err))
+				continue
+			}
+			if o.configs[platform] == nil {
+				o.configs[platform] = map[string]MethodConfig{}
+			}
+			o.configs[platform][function] = cfg
+		}
+		if o.custom[platform] {
+			if _, ok := o.configs[platform]["search"]; !ok {
+				o.Errs = append(o.Errs, fmt.Errorf("%s: no usable search.json; the platform can't be searched", filepath.Join(dir, pe.Name())))
+			}
+		}
+	}
+	return o
+}
+
+func readOverride(path string) (MethodConfig, error) {
+	var cfg MethodConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, ValidateMethod(cfg)
+}
+
+// Config returns the local config for platform/function, if there is one.
+func (o *Overrides) Config(platform, function string) (MethodConfig, bool) {
+	if o == nil {
+		return MethodConfig{}, false
+	}
+	cfg, ok := o.configs[strings.ToLower(strings.TrimSpace(platform))][strings.TrimSpace(function)]
+	return cfg, ok
+}
+
+// Custom reports whether platform is defined only locally.
+func (o *Overrides) Custom(platform string) bool {
+	return o != nil && o.custom[strings.ToLower(strings.TrimSpace(platform))]
+}
+
+// Platforms lists the user-defined platforms that can be searched.
+func (o *Overrides) Platforms() []string {
+	if o == nil {
+		return nil
+	}
+	var out []string
+	for p := range o.custom {
+		if _, ok := o.configs[p]["search"]; ok {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ValidateMethod reports the first problem that would stop cfg from running.
+func ValidateMethod(cfg MethodConfig) error {
+	if strings.TrimSpace(cfg.Transform) == "" {
+		return errors.New("missing transform")
+	}
+	if err := jsbox.CheckTransform(cfg.Transform); err != nil {
+		return fmt.Errorf("transform: %w", err)
+	}
+	if len(cfg.Steps) == 0 {
+		return validateRequest(cfg, true)
+	}
+	if t := strings.ToLower(cfg.Type); t != "" && t != "chain" {
+		return fmt.Errorf("config with steps must have type \"chain\", not %q", cfg.Type)
+	}
+	names, err := stepNames(cfg.Steps, nil)
+	if err != nil {
+		return err
+	}
+	for i, step := range cfg.Steps {
+		if err := validateRequest(step.MethodConfig, true); err != nil {
+			return fmt.Errorf("step %s: %w", names[i], err)
+		}
+		if strings.TrimSpace(step.Transform) != "" {
+			if err := jsbox.CheckTransform(step.Transform); err != nil {
+				return fmt.Errorf("step %s: transform: %w", names[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRequest checks the fields execMethod needs. strict also requires
+// an absolute http(s) URL, which TuneHub's configs have never been held to.
+func validateRequest(cfg MethodConfig, strict bool) error {
+	if strings.ToLower(cfg.Type) != "http" {
+		return fmt.Errorf("unsupported method type: %q", cfg.Type)
+	}
+	method := strings.ToUpper(strings.TrimSpace(cfg.Method))
+	if method != http.MethodGet && method != http.MethodPost {
+		return fmt.Errorf("unsupported http method: %q", cfg.Method)
+	}
+	if strings.TrimSpace(cfg.URL) == "" {
+		return errors.New("missing url")
+	}
+	if strict {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be absolute http or https: %q", cfg.URL)
+		}
+	}
+	return checkMethod(cfg)
+}
+
+// NeedsKey reports whether parsing on platform goes through TuneHub and so
+// needs an API key.
+func (c *Client) NeedsKey(platform string) bool {
+	_, ok := c.Overrides.Config(platform, "parse")
+	return !ok
+}
+
+// parseLocal resolves ids one at a time with the platform's local parse
+// config, which is called with {{ id }} and {{ quality }} and must return one
+// ParseItem. A track that fails is reported in its item, like TuneHub does.
+func (c *Client) parseLocal(ctx context.Context, platform, ids, quality string) (ParseData, error) {
+	var pd ParseData
+	for _, id := range splitIDs(ids) {
+		var it ParseItem
+		err := c.runMethod(ctx, platform, "parse", map[string]any{"id": id, "quality": quality}, &it)
+		if ctx.Err() != nil {
+			return ParseData{}, ctx.Err()
+		}
+		if err != nil {
+			it = ParseItem{Error: err.Error()}
+		}
+		it.ID = cmp.Or(it.ID, id)
+		it.Platform = platform
+		it.Quality = cmp.Or(it.Quality, quality)
+		it.ActualQuality = cmp.Or(it.ActualQuality, it.Quality)
+		it.Success = it.Success || (it.URL != "" && it.Error == "")
+		if it.Success {
+			pd.SuccessCount++
+		} else {
+			pd.FailCount++
+		}
+		pd.Data = append(pd.Data, it)
+	}
+	pd.Total = len(pd.Data)
+	return pd, nil
+}
@@ -0,0 +1,224 @@
+package tunehub
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMetaTTL is how long lyrics, cover URL and song info are kept.
+	DefaultMetaTTL = 30 * 24 * time.Hour
+	// urlMargin keeps a URL out of use shortly before it expires, so the
+	// download has time to finish.
+	urlMargin = 2 * time.Minute
+)
+
+// ParseCache keeps successful parse results so the same track isn't paid
+// for twice. A result is reused for its (platform, id, quality) until its
+// Expire time; the song's metadata and lyrics are kept for MetaTTL and
+// never need the network. With Dir set the cache survives restarts.
+type ParseCache struct {
+	Dir     string
+	MetaTTL time.Duration
+
+	mu    sync.Mutex
+	songs map[string]*parseEntry
+}
+
+type parseEntry struct {
+	// Meta is the last parse result with the URL fields cleared.
+	Meta    ParseItem `json:"meta"`
+	SavedAt time.Time `json:"savedAt"`
+	// HasLyrics is set once AttachLyrics stored the full lyric set.
+	HasLyrics bool                  `json:"hasLyrics,omitempty"`
+	URLs      map[string]cachedItem `json:"urls,omitempty"`
+}
+
+type cachedItem struct {
+	Item      ParseItem `json:"item"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func NewParseCache(dir string, metaTTL time.Duration) *ParseCache {
+	return &ParseCache{Dir: dir, MetaTTL: metaTTL, songs: map[string]*parseEntry{}}
+}
+
+// Lookup returns a cached item for quality whose URL is still usable.
+func (pc *ParseCache) Lookup(platform, id, quality string) (ParseItem, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entry(platform, id)
+	if e == nil {
+		return ParseItem{}, false
+	}
+	ci, ok := e.URLs[quality]
+	if !ok || time.Until(ci.ExpiresAt) < urlMargin {
+		return ParseItem{}, false
+	}
+	it := ci.Item
+	it.FromCache = true
+	pc.fillLyrics(e, &it)
+	return it, true
+}
+
+// Meta returns the cached song info, lyrics and cover URL for a track
+// without a usable audio URL.
+func (pc *ParseCache) Meta(platform, id string) (ParseItem, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entry(platform, id)
+	if e == nil {
+		return ParseItem{}, false
+	}
+	return e.Meta, true
+}
+
+// Store records a successful parse result for quality.
+func (pc *ParseCache) Store(platform, quality string, it ParseItem) {
+	if !it.Success || it.ID == "" {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entry(platform, it.ID)
+	if e == nil {
+		e = &parseEntry{}
+	}
+	meta := it
+	meta.URL, meta.FileSize, meta.Expire, meta.FromCache = "", 0, 0, false
+	if e.HasLyrics {
+		// Keep the lyric set AttachLyrics completed earlier.
+		meta.TransLyrics, meta.RomaLyrics = e.Meta.TransLyrics, e.Meta.RomaLyrics
+		meta.Lyrics = cmp.Or(meta.Lyrics, e.Meta.Lyrics)
+	}
+	e.Meta = meta
+	e.SavedAt = time.Now()
+	if exp, ok := expiresAt(it.Expire); ok {
+		if e.URLs == nil {
+			e.URLs = map[string]cachedItem{}
+		}
+		it.FromCache = false
+		e.URLs[quality] = cachedItem{Item: it, ExpiresAt: exp}
+	}
+	pc.put(platform, it.ID, e)
+}
+
+// storeLyrics records the full lyric set fetched for a track.
+func (pc *ParseCache) storeLyrics(platform string, it ParseItem) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entry(platform, it.ID)
+	if e == nil {
+		return
+	}
+	e.Meta.Lyrics, e.Meta.TransLyrics, e.Meta.RomaLyrics = it.Lyrics, it.TransLyrics, it.RomaLyrics
+	e.HasLyrics = true
+	pc.put(platform, it.ID, e)
+}
+
+// cachedLyrics fills it from a completed lyric set, if there is one.
+func (pc *ParseCache) cachedLyrics(platform string, it *ParseItem) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e := pc.entry(platform, it.ID)
+	if e == nil || !e.HasLyrics {
+		return false
+	}
+	pc.fillLyrics(e, it)
+	return true
+}
+
+func (pc *ParseCache) fillLyrics(e *parseEntry, it *ParseItem) {
+	if !e.HasLyrics {
+		return
+	}
+	it.Lyrics = cmp.Or(it.Lyrics, e.Meta.Lyrics)
+	it.TransLyrics = cmp.Or(it.TransLyrics, e.Meta.TransLyrics)
+	it.RomaLyrics = cmp.Or(it.RomaLyrics, e.Meta.RomaLyrics)
+}
+
+// expiresAt reads Expire, which TuneHub sends as a Unix time in seconds or
+// milliseconds. Anything else can't be trusted and isn't cached.
+func expiresAt(expire int64) (time.Time, bool) {
+	switch {
+	case expire > 1e12:
+		return time.UnixMilli(expire), true
+	case expire > 1e9:
+		return time.Unix(expire, 0), true
+	}
+	return time.Time{}, false
+}
+
+func (pc *ParseCache) metaTTL() time.Duration {
+	if pc.MetaTTL > 0 {
+		return pc.MetaTTL
+	}
+	return DefaultMetaTTL
+}
+
+func parseKey(platform, id string) string {
+	return strings.ToLower(strings.TrimSpace(platform)) + "/" + strings.TrimSpace(id)
+}
+
+// entry returns the live entry for a track, loading it from disk if needed.
+func (pc *ParseCache) entry(platform, id string) *parseEntry {
+	if pc.songs == nil {
+		pc.songs = map[string]*parseEntry{}
+	}
+	key := parseKey(platform, id)
+	e, ok := pc.songs[key]
+	if !ok && pc.Dir != "" {
+		if b, err := os.ReadFile(pc.path(platform, id)); err == nil {
+			var de parseEntry
+			if json.Unmarshal(b, &de) == nil {
+				e = &de
+				pc.songs[key] = e
+			}
+		}
+	}
+	if e == nil {
+		return nil
+	}
+	if time.Since(e.SavedAt) >= pc.metaTTL() {
+		delete(pc.songs, key)
+		if pc.Dir != "" {
+			_ = os.Remove(pc.path(platform, id))
+		}
+		return nil
+	}
+	return e
+}
+
+func (pc *ParseCache) put(platform, id string, e *parseEntry) {
+	pc.songs[parseKey(platform, id)] = e
+	if pc.Dir == "" {
+		return
+	}
+	// Like the method cache, the disk copy is best effort.
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	p := pc.path(platform, id)
+	if os.MkdirAll(filepath.Dir(p), 0o755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".parse-*")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(b)
+	if cerr := tmp.Close(); werr != nil || cerr != nil || os.Rename(tmp.Name(), p) != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+func (pc *ParseCache) path(platform, id string) string {
+	return filepath.Join(pc.Dir, "parses", url.PathEscape(strings.ToLower(strings.TrimSpace(platform))), url.PathEscape(strings.TrimSpace(id))+".json")
+}
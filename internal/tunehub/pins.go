@@ -0,0 +1,165 @@
+package tunehub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pins holds the content hash of every method config that has been
+// reviewed and accepted. A remote config that doesn't match its pin is
+// refused when Enforce is set and only warned about otherwise. Local
+// overrides are never checked.
+type Pins struct {
+	Path    string
+	Enforce bool
+
+	mu     sync.Mutex
+	pins   map[string]Pin
+	warned map[string]bool
+}
+
+// Pin is an accepted config. The config itself is kept so a later change
+// can be shown as a diff.
+type Pin struct {
+	Hash       string       `json:"hash"`
+	AcceptedAt time.Time    `json:"acceptedAt"`
+	Config     MethodConfig `json:"config"`
+}
+
+// PinError is returned for a remote config that doesn't match its pin.
+type PinError struct {
+	Platform string
+	Function string
+	// Want is the pinned hash, empty when the config was never accepted.
+	Want string
+	Got  string
+}
+
+func (e *PinError) Error() string {
+	if e.Want == "" {
+		return fmt.Sprintf("not pinned (%s)", shortHash(e.Got))
+	}
+	return fmt.Sprintf("changed since it was pinned (%s, now %s)", shortHash(e.Want), shortHash(e.Got))
+}
+
+func (e *PinError) Hint() string {
+	return "review it with `kotodama-kamataichi pins diff " + e.Platform + "/" + e.Function +
+		"` and run `kotodama-kamataichi pins accept " + e.Platform + "/" + e.Function + "` if it looks right"
+}
+
+// MethodHash identifies a config by its content.
+func MethodHash(cfg MethodConfig) string {
+	// encoding/json sorts map keys, so equal configs encode alike.
+	b, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func shortHash(h string) string {
+	if len(h) > len("sha256:")+12 {
+		return h[:len("sha256:")+12]
+	}
+	return h
+}
+
+// LoadPins reads the pin file at path; a missing file has no pins.
+func LoadPins(path string, enforce bool) (*Pins, error) {
+	p := &Pins{Path: path, Enforce: enforce, pins: map[string]Pin{}, warned: map[string]bool{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &p.pins); err != nil {
+		return nil, fmt.Errorf("pins %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Get returns the pin for platform/function.
+func (p *Pins) Get(platform, function string) (Pin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pin, ok := p.pins[platform+"/"+function]
+	return pin, ok
+}
+
+// Keys lists the pinned "platform/function" names in order.
+func (p *Pins) Keys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.pins))
+	for k := range p.pins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Accept pins cfg for platform/function and saves the file.
+func (p *Pins) Accept(platform, function string, cfg MethodConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pins[platform+"/"+function] = Pin{Hash: MethodHash(cfg), AcceptedAt: time.Now(), Config: cfg}
+	b, err := json.MarshalIndent(p.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.Path), ".pins-*")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(append(b, '\n'))
+	cerr := tmp.Close()
+	if err := errors.Join(werr, cerr); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p.Path)
+}
+
+// Check compares cfg with its pin.
+func (p *Pins) Check(platform, function string, cfg MethodConfig) *PinError {
+	got := MethodHash(cfg)
+	pin, ok := p.Get(platform, function)
+	if ok && pin.Hash == got {
+		return nil
+	}
+	return &PinError{Platform: platform, Function: function, Want: pin.Hash, Got: got}
+}
+
+// checkPin applies c.Pins to a config fetched from TuneHub. Each unpinned
+// version is warned about once.
+func (c *Client) checkPin(platform, function string, cfg MethodConfig) error {
+	if c.Pins == nil {
+		return nil
+	}
+	pe := c.Pins.Check(platform, function, cfg)
+	if pe == nil {
+		return nil
+	}
+	if c.Pins.Enforce {
+		return &Error{Reason: ReasonConfig, Op: "method config", Platform: platform, Function: function, Err: pe}
+	}
+	c.Pins.mu.Lock()
+	seen := c.Pins.warned[pe.Got]
+	c.Pins.warned[pe.Got] = true
+	c.Pins.mu.Unlock()
+	if !seen {
+		c.warnf("method config %s/%s %v; running it anyway", platform, function, pe)
+	}
+	return nil
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"kotodama-kamataichi/internal/netroute"
 )
 
 func (c *Client) searchQQ(ctx context.Context, keyword string, page, limit int) ([]SearchItem, error) {
@@ -26,25 +27,21 @@ func (c *Client) searchQQ(ctx context.Context, keyword string, page, limit int)
 	q.Set("format", "json")
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Referer", "https://y.qq.com/")
-	req.Header.Set("User-Agent", "kotodama-kamataichi")
-
-	res, err := c.http().Do(req)
+	ctx = netroute.WithPlatform(ctx, "qq")
+	res, body, err := c.send(ctx, "upstream", true, 2*1024*1024, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Referer", "https://y.qq.com/")
+		req.Header.Set("User-Agent", "kotodama-kamataichi")
+		return req, nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, withMethod(err, "qq", "search")
 	}
-	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("qq upstream http %d", res.StatusCode)
-	}
-
-	body, err := io.ReadAll(io.LimitReader(res.Body, 2*1024*1024))
-	if err != nil {
-		return nil, err
+		return nil, withMethod(upstreamError(res.StatusCode), "qq", "search")
 	}
 
 	var payload struct {
@@ -64,14 +61,14 @@ func (c *Client) searchQQ(ctx context.Context, keyword string, page, limit int)
 		} `json:"data"`
 	}
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return nil, err
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Platform: "qq", Function: "search", Message: "bad response", Err: err}
 	}
 	if payload.Code != 0 {
 		msg := strings.TrimSpace(payload.Message)
 		if msg == "" {
 			msg = "qq search failed"
 		}
-		return nil, fmt.Errorf("qq upstream: %s", msg)
+		return nil, &Error{Reason: ReasonUpstream, Op: "upstream", Platform: "qq", Function: "search", Code: payload.Code, Message: msg}
 	}
 
 	items := make([]SearchItem, 0, len(payload.Data.Song.List))
@@ -0,0 +1,233 @@
+package tunehub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how often a failed call is repeated. Waits grow
+// exponentially from BaseWait, with jitter, and never exceed MaxWait; a
+// Retry-After longer than MaxWait ends the retries instead.
+type RetryPolicy struct {
+	Attempts int
+	BaseWait time.Duration
+	MaxWait  time.Duration
+}
+
+var DefaultRetry = RetryPolicy{Attempts: 3, BaseWait: 500 * time.Millisecond, MaxWait: 8 * time.Second}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	base := p.BaseWait
+	if base <= 0 {
+		base = DefaultRetry.BaseWait
+	}
+	wait := min(base<<attempt, p.maxWait())
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func (p RetryPolicy) maxWait() time.Duration {
+	if p.MaxWait > 0 {
+		return p.MaxWait
+	}
+	return DefaultRetry.MaxWait
+}
+
+// Breaker fails calls to a host fast after Threshold consecutive failures.
+// Once Cooldown has passed a single probe is let through; its outcome closes
+// the breaker or opens it for another Cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+}
+
+type hostHealth struct {
+	fails     int
+	openUntil time.Time
+	probing   bool
+}
+
+// CircuitOpenError is returned without touching the network while a host's
+// breaker is open.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s keeps failing; not trying again until %s", e.Host, e.Until.Local().Format("15:04:05"))
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, hosts: map[string]*hostHealth{}}
+}
+
+func (b *Breaker) allow(host string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.host(host)
+	if h.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(h.openUntil) || h.probing {
+		return &CircuitOpenError{Host: host, Until: h.openUntil}
+	}
+	h.probing = true
+	return nil
+}
+
+// done records a call's outcome and reports whether it opened the breaker.
+func (b *Breaker) done(host string, failed bool) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.host(host)
+	h.probing = false
+	if !failed {
+		*h = hostHealth{}
+		return false
+	}
+	h.fails++
+	if h.fails < max(b.Threshold, 1) {
+		return false
+	}
+	h.openUntil = time.Now().Add(b.Cooldown)
+	return true
+}
+
+// release forgets a call that was cancelled before it had an outcome.
+func (b *Breaker) release(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.host(host).probing = false
+	b.mu.Unlock()
+}
+
+func (b *Breaker) host(host string) *hostHealth {
+	if b.hosts == nil {
+		b.hosts = map[string]*hostHealth{}
+	}
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostHealth{}
+		b.hosts[host] = h
+	}
+	return h
+}
+
+// send performs the request build returns, retrying transient failures per
+// c.Retry and reading at most limit bytes of the final body. Calls that
+// aren't idempotent are only repeated when the server can't have acted on
+// them: the connection never opened, or it answered 429/503.
+func (c *Client) send(ctx context.Context, op string, idempotent bool, limit int64, build func() (*http.Request, error)) (*http.Response, []byte, error) {
+	attempts := max(c.Retry.Attempts, 1)
+	for attempt := 0; ; attempt++ {
+		req, err := build()
+		if err != nil {
+			return nil, nil, err
+		}
+		client := c.route(req)
+		host := req.URL.Host
+		if err := c.Breaker.allow(host); err != nil {
+			return nil, nil, netError(op, err)
+		}
+
+		res, body, err := roundTrip(client, req, limit)
+		if ctx.Err() != nil {
+			c.Breaker.release(host)
+		} else if c.Breaker.done(host, err != nil || res.StatusCode >= 500) {
+			c.warnf("%s is not responding; failing fast for %s", host, c.Breaker.Cooldown)
+		}
+
+		wait, retry := c.retryAfter(attempt, idempotent, res, err)
+		if !retry || attempt+1 >= attempts || ctx.Err() != nil {
+			if err != nil {
+				return nil, nil, netError(op, err)
+			}
+			return res, body, nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if err != nil {
+				return nil, nil, netError(op, err)
+			}
+			return res, body, nil
+		case <-t.C:
+		}
+	}
+}
+
+func roundTrip(client *http.Client, req *http.Request, limit int64) (*http.Response, []byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit))
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, body, nil
+}
+
+// retryAfter decides whether an attempt is worth repeating and how long to
+// wait first.
+func (c *Client) retryAfter(attempt int, idempotent bool, res *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return 0, false
+		}
+		var oe *net.OpError
+		if !idempotent && !(errors.As(err, &oe) && oe.Op == "dial") {
+			return 0, false
+		}
+		return c.Retry.backoff(attempt), true
+	}
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+	case res.StatusCode >= 500 && idempotent:
+	default:
+		return 0, false
+	}
+	if ra, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+		if ra > c.Retry.maxWait() {
+			return 0, false
+		}
+		return ra, true
+	}
+	return c.Retry.backoff(attempt), true
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,379 @@
+package tunehub
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema is what a method function's transform must return beyond the Go
+// type it decodes into.
+type Schema struct {
+	// Required fields must not be empty. A path walks into arrays on its
+	// own: "id" on a track list checks every track's id, and a track
+	// without one is dropped rather than failing the list.
+	Required []string
+	// ArrayOf names the field a bare array fills when the transform returns
+	// one instead of the object.
+	ArrayOf string
+}
+
+// Schemas are declared per method function. Functions not listed are only
+// coerced to their Go type.
+var Schemas = map[string]Schema{
+	"search":   {Required: []string{"id"}},
+	"playlist": {Required: []string{"tracks.id"}, ArrayOf: "tracks"},
+	"album":    {Required: []string{"tracks.id"}, ArrayOf: "tracks"},
+	"artist":   {Required: []string{"tracks.id"}, ArrayOf: "tracks"},
+	"lyric":    {},
+	"parse":    {},
+}
+
+// SchemaError names the first value in a transform's output that doesn't
+// fit, e.g. "[3].id: want a string, got an array".
+type SchemaError struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// decodeOutput fills out (a pointer) from a transform result, coercing the
+// common near misses: numbers where strings are expected, artist objects or
+// arrays where a name is, numeric strings where numbers are. Array items
+// missing a required value are dropped; skipped names where each was.
+func decodeOutput(function string, v any, out any) (skipped []string, err error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return nil, fmt.Errorf("decode %s: need a pointer, not %T", function, out)
+	}
+	s := Schemas[function]
+	if arr, ok := v.([]any); ok && s.ArrayOf != "" {
+		v = map[string]any{s.ArrayOf: arr}
+	}
+	if err := coerce(v, rv.Elem(), ""); err != nil {
+		return nil, err
+	}
+	for _, path := range s.Required {
+		dropped, err := dropIncomplete(rv.Elem(), strings.Split(path, "."), "")
+		if err != nil {
+			return nil, err
+		}
+		skipped = append(skipped, dropped...)
+	}
+	return skipped, nil
+}
+
+func schemaErr(path, format string, args ...any) error {
+	return &SchemaError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+func coerce(v any, dst reflect.Value, path string) error {
+	if dst.Type() == reflect.TypeFor[json.RawMessage]() {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return schemaErr(path, "%v", err)
+		}
+		dst.SetBytes(b)
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Interface:
+		if v != nil {
+			dst.Set(reflect.ValueOf(v))
+		}
+		return nil
+	case reflect.String:
+		s, err := toString(v, path)
+		dst.SetString(s)
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Exact when the number is an integer literal; float64 would
+		// round past 2^53.
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil && !dst.OverflowInt(i) {
+				dst.SetInt(i)
+				return nil
+			}
+		}
+		f, err := toNumber(v, path)
+		if err != nil {
+			return err
+		}
+		if f != math.Trunc(f) || dst.OverflowInt(int64(f)) {
+			return schemaErr(path, "want an integer, got %v", f)
+		}
+		dst.SetInt(int64(f))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := toNumber(v, path)
+		dst.SetFloat(f)
+		return err
+	case reflect.Bool:
+		switch x := v.(type) {
+		case nil:
+		case bool:
+			dst.SetBool(x)
+		case float64:
+			dst.SetBool(x != 0)
+		case json.Number:
+			f, _ := x.Float64()
+			dst.SetBool(f != 0)
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(x))
+			if err != nil {
+				return schemaErr(path, "want a boolean, got %q", x)
+			}
+			dst.SetBool(b)
+		default:
+			return schemaErr(path, "want a boolean, got %s", kindOf(v))
+		}
+		return nil
+	case reflect.Slice:
+		if v == nil {
+			return nil
+		}
+		arr, ok := v.([]any)
+		if !ok {
+			return schemaErr(path, "want an array, got %s", kindOf(v))
+		}
+		s := reflect.MakeSlice(dst.Type(), len(arr), len(arr))
+		for i, e := range arr {
+			if err := coerce(e, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Struct:
+		if v == nil {
+			return nil
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return schemaErr(path, "want an object, got %s", kindOf(v))
+		}
+		t := dst.Type()
+		for i := range t.NumField() {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			if err := coerce(lookupKey(obj, name), dst.Field(i), joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Anything else decodes the way it always did.
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, dst.Addr().Interface())
+	}
+	if err != nil {
+		return schemaErr(path, "%v", err)
+	}
+	return nil
+}
+
+// toString accepts numbers (platform IDs often are), and a name list for
+// fields like artist: ["A", "B"] or [{"name": "A"}, …] become "A, B".
+func toString(v any, path string) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	case json.Number:
+		// The literal as sent, so long IDs keep every digit.
+		return x.String(), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case map[string]any:
+		if name, ok := nameOf(x); ok {
+			return name, nil
+		}
+		return "", schemaErr(path, "want a string, got an object without a name")
+	case []any:
+		names := make([]string, 0, len(x))
+		for i, e := range x {
+			s, err := toString(e, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return "", err
+			}
+			if s != "" {
+				names = append(names, s)
+			}
+		}
+		return strings.Join(names, ", "), nil
+	}
+	return "", schemaErr(path, "want a string, got %s", kindOf(v))
+}
+
+func toNumber(v any, path string) (float64, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return x, nil
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return 0, schemaErr(path, "want a number, got %s", x)
+		}
+		return f, nil
+	case string:
+		if strings.TrimSpace(x) == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, schemaErr(path, "want a number, got %q", x)
+		}
+		return f, nil
+	}
+	return 0, schemaErr(path, "want a number, got %s", kindOf(v))
+}
+
+// dropIncomplete removes the array items whose value at path is empty, so
+// one bad track doesn't cost the whole list, and returns where each was. A
+// required value outside any array is still an error.
+func dropIncomplete(v reflect.Value, path []string, at string) ([]string, error) {
+	if v.Kind() == reflect.Slice {
+		var dropped []string
+		kept := 0
+		for i := range v.Len() {
+			item, itemAt := v.Index(i), fmt.Sprintf("%s[%d]", at, i)
+			if p, ok := missing(item, path, itemAt); ok {
+				dropped = append(dropped, p)
+				continue
+			}
+			d, err := dropIncomplete(item, path, itemAt)
+			if err != nil {
+				return nil, err
+			}
+			dropped = append(dropped, d...)
+			v.Index(kept).Set(item)
+			kept++
+		}
+		v.SetLen(kept)
+		return dropped, nil
+	}
+	if len(path) == 0 {
+		if v.IsZero() {
+			return nil, schemaErr(at, "required")
+		}
+		return nil, nil
+	}
+	if f, ok := fieldByJSON(v, path[0]); ok {
+		return dropIncomplete(f, path[1:], joinPath(at, path[0]))
+	}
+	return nil, nil
+}
+
+// missing reports whether the value at path inside item is empty, and its
+// location. Paths that lead into a nested array are left to dropIncomplete.
+func missing(item reflect.Value, path []string, at string) (string, bool) {
+	for _, name := range path {
+		f, ok := fieldByJSON(item, name)
+		if !ok {
+			return "", false
+		}
+		item, at = f, joinPath(at, name)
+		if item.Kind() == reflect.Slice {
+			return "", false
+		}
+	}
+	return at, item.IsZero()
+}
+
+func fieldByJSON(v reflect.Value, name string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	for i := range t.NumField() {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// lookupKey finds a field's value the way encoding/json does: the exact key
+// first, then one equal under case folding ("ID" for "id").
+func lookupKey(obj map[string]any, name string) any {
+	if v, ok := obj[name]; ok {
+		return v
+	}
+	// Of several folded matches, take the first in sort order so the
+	// result doesn't depend on map iteration.
+	match := ""
+	for k := range obj {
+		if strings.EqualFold(k, name) && (match == "" || k < match) {
+			match = k
+		}
+	}
+	if match == "" {
+		return nil
+	}
+	return obj[match]
+}
+
+func nameOf(obj map[string]any) (string, bool) {
+	for _, k := range []string{"name", "title"} {
+		if s, ok := lookupKey(obj, k).(string); ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func kindOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case float64, json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,189 @@
+package tunehub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSearchTTL     = 10 * time.Minute
+	DefaultSearchEntries = 200
+)
+
+// SearchCache keeps recent search results keyed on (platform, keyword, page,
+// limit). Stale entries are still returned by Lookup so callers can show
+// them while refreshing. With Dir set the cache survives restarts.
+type SearchCache struct {
+	TTL        time.Duration
+	MaxEntries int
+	Dir        string
+
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]*searchEntry
+	gen     uint64 // bumped by every Store
+
+	// saveMu orders file writes, which happen outside mu; saved is the
+	// generation on disk.
+	saveMu sync.Mutex
+	saved  uint64
+}
+
+type searchEntry struct {
+	Items     []SearchItem `json:"items"`
+	FetchedAt time.Time    `json:"fetchedAt"`
+	UsedAt    time.Time    `json:"usedAt"`
+}
+
+type SearchHit struct {
+	Items     []SearchItem
+	FetchedAt time.Time
+	Stale     bool
+}
+
+func NewSearchCache(ttl time.Duration, maxEntries int, dir string) *SearchCache {
+	return &SearchCache{TTL: ttl, MaxEntries: maxEntries, Dir: dir, entries: map[string]*searchEntry{}}
+}
+
+func searchKey(platform, keyword string, page, limit int) string {
+	// Only whitespace is normalized: platforms may rank "Love" and "love"
+	// differently, so they are separate searches.
+	keyword = strings.Join(strings.Fields(keyword), " ")
+	return strings.ToLower(strings.TrimSpace(platform)) + "\x00" + keyword + "\x00" + strconv.Itoa(page) + "\x00" + strconv.Itoa(limit)
+}
+
+func (sc *SearchCache) Lookup(platform, keyword string, page, limit int) (SearchHit, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.load()
+	e, ok := sc.entries[searchKey(platform, keyword, page, limit)]
+	if !ok {
+		return SearchHit{}, false
+	}
+	e.UsedAt = time.Now()
+	return SearchHit{
+		Items:     append([]SearchItem(nil), e.Items...),
+		FetchedAt: e.FetchedAt,
+		Stale:     time.Since(e.FetchedAt) >= sc.ttl(),
+	}, true
+}
+
+func (sc *SearchCache) Store(platform, keyword string, page, limit int, items []SearchItem) {
+	sc.mu.Lock()
+	sc.load()
+	now := time.Now()
+	sc.entries[searchKey(platform, keyword, page, limit)] = &searchEntry{
+		Items:     append([]SearchItem(nil), items...),
+		FetchedAt: now,
+		UsedAt:    now,
+	}
+	maxN := sc.MaxEntries
+	if maxN <= 0 {
+		maxN = DefaultSearchEntries
+	}
+	for len(sc.entries) > maxN {
+		var oldest string
+		for k, e := range sc.entries {
+			if oldest == "" || e.UsedAt.Before(sc.entries[oldest].UsedAt) {
+				oldest = k
+			}
+		}
+		delete(sc.entries, oldest)
+	}
+	sc.gen++
+	gen := sc.gen
+	var snapshot []byte
+	if sc.Dir != "" {
+		snapshot, _ = json.Marshal(sc.entries)
+	}
+	sc.mu.Unlock()
+
+	// Lookups don't wait for the disk.
+	sc.save(snapshot, gen)
+}
+
+func (sc *SearchCache) ttl() time.Duration {
+	if sc.TTL > 0 {
+		return sc.TTL
+	}
+	return DefaultSearchTTL
+}
+
+func (sc *SearchCache) file() string {
+	return filepath.Join(sc.Dir, "search.json")
+}
+
+// load and save are best effort; the cache works the same without the file.
+func (sc *SearchCache) load() {
+	if sc.loaded {
+		return
+	}
+	sc.loaded = true
+	if sc.entries == nil {
+		sc.entries = map[string]*searchEntry{}
+	}
+	if sc.Dir == "" {
+		return
+	}
+	b, err := os.ReadFile(sc.file())
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(b, &sc.entries)
+	// A hand-edited or damaged file may hold nulls; they're misses.
+	for k, e := range sc.entries {
+		if e == nil {
+			delete(sc.entries, k)
+		}
+	}
+}
+
+// save writes a snapshot taken by Store, unless a newer one is already on
+// disk.
+func (sc *SearchCache) save(b []byte, gen uint64) {
+	if b == nil {
+		return
+	}
+	sc.saveMu.Lock()
+	defer sc.saveMu.Unlock()
+	if gen <= sc.saved || os.MkdirAll(sc.Dir, 0o755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(sc.Dir, ".search-*")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(b)
+	if cerr := tmp.Close(); werr != nil || cerr != nil || os.Rename(tmp.Name(), sc.file()) != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	sc.saved = gen
+}
+
+// CachedSearch returns what the search cache holds for the query, fresh or
+// not, without touching the network.
+func (c *Client) CachedSearch(platform, keyword string, page, limit int) (SearchHit, bool) {
+	if c.Searches == nil {
+		return SearchHit{}, false
+	}
+	return c.Searches.Lookup(platform, keyword, page, limit)
+}
+
+// SearchFresh always asks upstream and refreshes the cache with the answer.
+func (c *Client) SearchFresh(ctx context.Context, platform, keyword string, page, limit int) ([]SearchItem, error) {
+	items, err := c.search(ctx, platform, keyword, page, limit)
+	if err != nil {
+		return nil, err
+	}
+	if c.Searches != nil {
+		c.Searches.Store(platform, keyword, page, limit, items)
+	}
+	return items, nil
+}
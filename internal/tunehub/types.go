@@ -1,5 +1,10 @@
 package tunehub
 
+import (
+	"context"
+	"time"
+)
+
 type APIResponse[T any] struct {
 	Code    int    `json:"code"`
 	Success bool   `json:"success"`
@@ -15,6 +20,32 @@ type MethodConfig struct {
 	Body      map[string]any    `json:"body"`
 	Headers   map[string]string `json:"headers"`
 	Transform string            `json:"transform"`
+	// BodyEncoding is "json" (the default) or "form".
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+	// Response is "json", "jsonp", "text" or "xml". Empty decodes JSON when
+	// it can and passes anything else on as text.
+	Response string `json:"response,omitempty"`
+	// Charset overrides the response charset, e.g. "gbk".
+	Charset string `json:"charset,omitempty"`
+	// Cookies are sent with the request; values may use {{ }} like Params.
+	Cookies map[string]string `json:"cookies,omitempty"`
+	// CookieJar keeps cookies the platform sets and sends them back on its
+	// later method calls.
+	CookieJar bool `json:"cookieJar,omitempty"`
+	// MaxBytes bounds the response; 0 means 4 MiB.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	// Steps makes the config a chain of HTTP calls run in order; the
+	// top-level request fields are then unused. Transform sees every step's
+	// output keyed by step name.
+	Steps []MethodStep `json:"steps,omitempty"`
+}
+
+// MethodStep is one call in a chained config. Its templates can use the
+// outputs of the steps before it, e.g. {{ step1.token }}.
+type MethodStep struct {
+	// Name defaults to "step1", "step2", … by position.
+	Name string `json:"name,omitempty"`
+	MethodConfig
 }
 
 type SearchItem struct {
@@ -24,6 +55,12 @@ type SearchItem struct {
 	Album  string `json:"album"`
 }
 
+type LyricSet struct {
+	Lyric string `json:"lyric"`
+	Trans string `json:"trans"`
+	Roma  string `json:"roma"`
+}
+
 type ParseRequest struct {
 	Platform string `json:"platform"`
 	IDs      string `json:"ids"`
@@ -31,10 +68,12 @@ type ParseRequest struct {
 }
 
 type ParseSongInfo struct {
-	Name     string `json:"name"`
-	Artist   string `json:"artist"`
-	Album    string `json:"album"`
-	Duration int    `json:"duration"`
+	Name   string `json:"name"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// Duration is in seconds, whatever unit the platform itself uses;
+	// local parse configs must convert too.
+	Duration int `json:"duration"`
 }
 
 type ParseItem struct {
@@ -44,6 +83,8 @@ type ParseItem struct {
 	Info          ParseSongInfo `json:"info"`
 	Cover         string        `json:"cover"`
 	Lyrics        string        `json:"lyrics"`
+	TransLyrics   string        `json:"transLyrics,omitempty"`
+	RomaLyrics    string        `json:"romaLyrics,omitempty"`
 	Quality       string        `json:"quality"`
 	ActualQuality string        `json:"actualQuality"`
 	WasDowngraded bool          `json:"wasDowngraded"`
@@ -51,6 +92,9 @@ type ParseItem struct {
 	Expire        int64         `json:"expire"`
 	FromCache     bool          `json:"fromCache"`
 	Error         string        `json:"error"`
+	// Platform isn't sent by TuneHub; Parse fills it in so meta.json records
+	// where the track came from.
+	Platform string `json:"platform,omitempty"`
 }
 
 type ParseData struct {
@@ -61,3 +105,25 @@ type ParseData struct {
 	CacheHitCount int         `json:"cache_hit_count"`
 	Cost          float64     `json:"cost"`
 }
+
+// ParseRecord is what a ParseMeter learns about one successful Parse call.
+type ParseRecord struct {
+	Time      time.Time
+	Platform  string
+	IDs       []string
+	Quality   string
+	Cost      float64
+	CacheHits int
+	Succeeded int
+	Failed    int
+	// Local marks answers served from the client's parse cache; they never
+	// reached TuneHub and cost nothing.
+	Local bool
+}
+
+// ParseMeter accounts for Parse calls, which spend TuneHub quota.
+type ParseMeter interface {
+	// BeforeParse may refuse the call, e.g. once a budget is spent.
+	BeforeParse(ctx context.Context, platform string, ids []string) error
+	AfterParse(ctx context.Context, rec ParseRecord)
+}
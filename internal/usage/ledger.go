@@ -0,0 +1,252 @@
+// Package usage records what TuneHub parse calls cost and enforces budgets.
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kotodama-kamataichi/internal/tunehub"
+)
+
+// Record is one line of the ledger file.
+type Record struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user,omitempty"`
+	Batch     string    `json:"batch,omitempty"`
+	Platform  string    `json:"platform"`
+	IDs       []string  `json:"ids"`
+	Quality   string    `json:"quality"`
+	Cost      float64   `json:"cost"`
+	CacheHits int       `json:"cacheHits"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	// Local records answers from the local parse cache, which cost nothing.
+	Local bool `json:"local,omitempty"`
+}
+
+// Ledger appends a Record for every Parse call to a JSONL file and refuses
+// new calls once DailyBudget or BatchBudget (0 = unlimited) is spent. It
+// implements tunehub.ParseMeter.
+type Ledger struct {
+	Path        string
+	User        string
+	DailyBudget float64
+	BatchBudget float64
+
+	mu      sync.Mutex
+	loaded  bool
+	day     string
+	today   float64
+	session float64
+	// unit is the latest cost per charged track, used to estimate a call
+	// before it is made; 0 until one has been seen.
+	unit float64
+}
+
+var _ tunehub.ParseMeter = (*Ledger)(nil)
+
+// BudgetError is returned instead of parsing once a budget is used up, or
+// when the call is expected to cost more than what is left of it.
+type BudgetError struct {
+	Scope string // "day" or "batch"
+	Spent float64
+	Limit float64
+	// Need is the estimated cost of the refused call; 0 when the budget was
+	// simply spent.
+	Need float64
+}
+
+func (e *BudgetError) Error() string {
+	if e.Need > 0 && e.Spent < e.Limit {
+		return fmt.Sprintf("%s budget: %g of %g spent, the next parse needs about %.4g", e.Scope, e.Spent, e.Limit, e.Need)
+	}
+	return fmt.Sprintf("%s budget spent: %g of %g", e.Scope, e.Spent, e.Limit)
+}
+
+func (e *BudgetError) Hint() string {
+	if e.Scope == "batch" {
+		return "raise the batch budget (-batch-budget) or download fewer tracks at once"
+	}
+	return "wait until tomorrow or raise the daily budget (-daily-budget)"
+}
+
+type batchKey struct{}
+
+type batch struct {
+	name  string
+	mu    sync.Mutex
+	spent float64
+}
+
+// WithBatch tags Parse calls made with ctx as one batch for the ledger and
+// the batch budget.
+func WithBatch(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, batchKey{}, &batch{name: name})
+}
+
+func batchOf(ctx context.Context) *batch {
+	b, _ := ctx.Value(batchKey{}).(*batch)
+	return b
+}
+
+// BeforeParse refuses a call that would take a budget past its limit,
+// estimating its cost from the last charged call, so a large batch can't
+// overshoot in one go.
+func (l *Ledger) BeforeParse(ctx context.Context, platform string, ids []string) error {
+	if l.BatchBudget <= 0 && l.DailyBudget <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	l.load()
+	l.rollDay()
+	today, need := l.today, l.unit*float64(len(ids))
+	l.mu.Unlock()
+
+	if b := batchOf(ctx); b != nil && l.BatchBudget > 0 {
+		b.mu.Lock()
+		spent := b.spent
+		b.mu.Unlock()
+		if spent >= l.BatchBudget || spent+need > l.BatchBudget {
+			return &BudgetError{Scope: "batch", Spent: spent, Limit: l.BatchBudget, Need: need}
+		}
+	}
+	if l.DailyBudget > 0 && (today >= l.DailyBudget || today+need > l.DailyBudget) {
+		return &BudgetError{Scope: "day", Spent: today, Limit: l.DailyBudget, Need: need}
+	}
+	return nil
+}
+
+func (l *Ledger) AfterParse(ctx context.Context, rec tunehub.ParseRecord) {
+	r := Record{
+		Time:      rec.Time.UTC(),
+		User:      l.User,
+		Platform:  rec.Platform,
+		IDs:       rec.IDs,
+		Quality:   rec.Quality,
+		Cost:      rec.Cost,
+		CacheHits: rec.CacheHits,
+		Succeeded: rec.Succeeded,
+		Failed:    rec.Failed,
+		Local:     rec.Local,
+	}
+	if b := batchOf(ctx); b != nil {
+		r.Batch = b.name
+		b.mu.Lock()
+		b.spent += rec.Cost
+		b.mu.Unlock()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.load()
+	l.rollDay()
+	l.today += rec.Cost
+	l.session += rec.Cost
+	l.learnUnit(r)
+	if l.Path == "" {
+		return
+	}
+	// Losing a ledger line must not fail a download that already paid.
+	_ = appendRecord(l.Path, r)
+}
+
+// Today is the cost recorded since local midnight.
+func (l *Ledger) Today() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.load()
+	l.rollDay()
+	return l.today
+}
+
+// Session is the cost of the parses made through l in this process.
+func (l *Ledger) Session() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.session
+}
+
+func (l *Ledger) rollDay() {
+	if d := time.Now().Format(time.DateOnly); d != l.day {
+		l.day, l.today = d, 0
+	}
+}
+
+// load sums today's cost from the file once; after that the ledger keeps
+// count itself.
+func (l *Ledger) load() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+	l.rollDay()
+	if l.Path == "" {
+		return
+	}
+	recs, _ := Read(l.Path)
+	for _, r := range recs {
+		if r.Time.Local().Format(time.DateOnly) == l.day {
+			l.today += r.Cost
+		}
+		l.learnUnit(r)
+	}
+}
+
+// learnUnit updates the per-track estimate from a charged call.
+func (l *Ledger) learnUnit(r Record) {
+	charged := r.Succeeded - r.CacheHits
+	if r.Local || r.Cost <= 0 || charged <= 0 {
+		return
+	}
+	l.unit = r.Cost / float64(charged)
+}
+
+func appendRecord(path string, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(append(b, '\n'))
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	return werr
+}
+
+// Read returns every record in the ledger at path; a missing file is empty.
+// Lines that don't parse are skipped.
+func Read(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []Record
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var r Record
+		if json.Unmarshal(sc.Bytes(), &r) == nil {
+			recs = append(recs, r)
+		}
+	}
+	return recs, sc.Err()
+}
@@ -0,0 +1,77 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Grouping keys for Summarize.
+const (
+	ByDay      = "day"
+	ByBatch    = "batch"
+	ByPlatform = "platform"
+	ByUser     = "user"
+)
+
+type Summary struct {
+	Key       string
+	Calls     int
+	Tracks    int
+	CacheHits int
+	Cost      float64
+}
+
+// Summarize totals the records made at or after since, grouped by one of
+// the By* keys. Days sort oldest first, everything else by cost.
+func Summarize(recs []Record, since time.Time, by string) ([]Summary, error) {
+	key := func(r Record) string { return r.Time.Local().Format(time.DateOnly) }
+	switch by {
+	case ByDay:
+	case ByBatch:
+		key = func(r Record) string { return orNone(r.Batch) }
+	case ByPlatform:
+		key = func(r Record) string { return r.Platform }
+	case ByUser:
+		key = func(r Record) string { return orNone(r.User) }
+	default:
+		return nil, fmt.Errorf("unknown grouping %q (want day, batch, platform or user)", by)
+	}
+
+	idx := map[string]int{}
+	var out []Summary
+	for _, r := range recs {
+		if r.Time.Before(since) {
+			continue
+		}
+		k := key(r)
+		i, ok := idx[k]
+		if !ok {
+			i = len(out)
+			idx[k] = i
+			out = append(out, Summary{Key: k})
+		}
+		s := &out[i]
+		// Local cache answers are counted as tracks, not as TuneHub calls.
+		if !r.Local {
+			s.Calls++
+		}
+		s.Tracks += len(r.IDs)
+		s.CacheHits += r.CacheHits
+		s.Cost += r.Cost
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if by == ByDay {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Cost > out[j].Cost
+	})
+	return out, nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}